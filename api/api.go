@@ -1,56 +1,359 @@
 package api
 
-import "reflect"
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strconv"
+)
 
+// ToApiData converts data into the generic maps, slices and scalars that make
+// up its API representation. Struct fields are named, omitted and flattened
+// following the same rules as encoding/json, and fields tagged
+// `api:"exclude"` are left out. Types may customize their representation by
+// implementing ApiMarshaler, json.Marshaler or encoding.TextMarshaler. Values
+// that refer back to themselves are rendered as nil where the cycle would
+// start over.
+//
+// Fields restricted to roles are rendered for an anonymous viewer, see
+// ToApiDataFor.
 func ToApiData(data interface{}) interface{} {
-	v := reflect.ValueOf(data)
+	return ToApiDataFor(data, nil)
+}
+
+type encodeState struct {
+	// References on the path to the current value, for cycle detection
+	seen   map[seenKey]struct{}
+	viewer Viewer
+	// Expansions requested below the current value
+	expand fieldTree
+	naming NamingStrategy
+}
+
+type seenKey struct {
+	ptr    uintptr
+	typ    reflect.Type
+	length int
+}
+
+func (e *encodeState) value(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+
+	// Pointers are guarded before marshaling so marshalers that return values
+	// pointing back to themselves don't recurse forever
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		return e.guard(v, func() interface{} {
+			if marshaled, ok := e.marshaled(v); ok {
+				return marshaled
+			}
+			return e.value(v.Elem())
+		})
+	}
 
-	switch reflect.TypeOf(data).Kind() {
-	case reflect.Ptr:
-		v = reflect.ValueOf(data).Elem()
+	if marshaled, ok := e.marshaled(v); ok {
+		return marshaled
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return e.value(v.Elem())
+	case reflect.Struct:
+		return e.structValue(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		return e.guard(v, func() interface{} {
+			return e.mapValue(v)
+		})
 	case reflect.Slice:
-		s := reflect.ValueOf(data)
-		asSlice := make([]interface{}, s.Len())
-		for index := 0; index < s.Len(); index++ {
-			asSlice[index] = ToApiData(s.Index(index).Interface())
+		if v.IsNil() {
+			return nil
 		}
-		return asSlice
+		// Byte slices are base64 encoded like encoding/json does
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return base64.StdEncoding.EncodeToString(v.Bytes())
+		}
+		return e.guard(v, func() interface{} {
+			return e.arrayValue(v)
+		})
+	case reflect.Array:
+		return e.arrayValue(v)
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		// Not representable in JSON
+		return nil
 	default:
-		return data
+		return scalarValue(v)
 	}
+}
 
-	numberOfFields := v.NumField()
+// guard renders a reference type, returning nil instead of recursing if the
+// same reference is already being rendered further up
+func (e *encodeState) guard(v reflect.Value, render func() interface{}) interface{} {
+	key := seenKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.length = v.Len()
+	}
+
+	if _, ok := e.seen[key]; ok {
+		return nil
+	}
+	e.seen[key] = struct{}{}
+	defer delete(e.seen, key)
+
+	return render()
+}
 
-	res := make(map[string]interface{}, numberOfFields)
+func (e *encodeState) structValue(v reflect.Value) interface{} {
+	fields := cachedTypeFields(v.Type())
 
-	for i := 0; i < numberOfFields; i++ {
-		fieldInfo := v.Type().Field(i)
+	res := make(map[string]interface{}, len(fields))
 
-		if fieldInfo.Tag.Get("api") == "exclude" {
+	for _, f := range fields {
+		fv, expand, ok := e.fieldValue(v, f)
+		if !ok {
 			continue
 		}
 
-		fieldName := fieldInfo.Name
-		if fieldInfo.Tag.Get("json") != "" {
-			fieldName = fieldInfo.Tag.Get("json")
+		name := e.fieldName(f)
+
+		if f.quoted {
+			res[name] = e.redacted(f.redaction, quotedValue(fv))
+			continue
 		}
 
-		fieldData := v.Field(i).Interface()
+		parentExpand := e.expand
+		e.expand = expand
+		res[name] = e.redacted(f.redaction, e.value(fv))
+		e.expand = parentExpand
+	}
 
-		switch v.Field(i).Kind() {
-		case reflect.Struct, reflect.Ptr:
-			res[fieldName] = ToApiData(fieldData)
-		case reflect.Slice:
-			s := reflect.ValueOf(fieldData)
-			asSlice := make([]interface{}, s.Len())
-			for index := 0; index < s.Len(); index++ {
-				asSlice[index] = ToApiData(s.Index(index).Interface())
-			}
-			res[fieldName] = asSlice
-		default:
-			res[fieldName] = fieldData
+	return res
+}
+
+// fieldValue returns the value of field f of struct v along with the
+// expansions requested below it. It reports false if the field shouldn't be
+// rendered at all.
+func (e *encodeState) fieldValue(v reflect.Value, f field) (reflect.Value, fieldTree, bool) {
+	if !e.canView(f.roles) {
+		return reflect.Value{}, nil, false
+	}
+
+	expand, expanded := e.expand[e.fieldName(f)]
+	if f.expandable && !expanded {
+		return reflect.Value{}, nil, false
+	}
+
+	fv, ok := fieldByIndex(v, f.index)
+	if !ok {
+		return reflect.Value{}, nil, false
+	}
+
+	if f.omitEmpty && isEmptyValue(fv) {
+		return reflect.Value{}, nil, false
+	}
+
+	return fv, expand, true
+}
+
+func (e *encodeState) mapValue(v reflect.Value) interface{} {
+	res := make(map[string]interface{}, v.Len())
+
+	iter := v.MapRange()
+	for iter.Next() {
+		key, ok := mapKey(iter.Key())
+		if !ok {
+			continue
 		}
+
+		// Map keys take part in expansion paths like field names
+		parentExpand := e.expand
+		e.expand = e.expand[key]
+		res[key] = e.value(iter.Value())
+		e.expand = parentExpand
 	}
 
 	return res
 }
+
+// interfaceValue renders data like value does, without reflection for the
+// values that ApiMarshaler implementations, such as the methods generated by
+// apigen, usually return
+func (e *encodeState) interfaceValue(data interface{}) interface{} {
+	switch data := data.(type) {
+	case nil:
+		return nil
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return data
+	case Fields:
+		if data == nil {
+			return nil
+		}
+		return e.guard(reflect.ValueOf(data), func() interface{} {
+			for key, value := range data {
+				parentExpand := e.expand
+				e.expand = e.expand[key]
+				data[key] = e.interfaceValue(value)
+				e.expand = parentExpand
+			}
+			return map[string]interface{}(data)
+		})
+	case map[string]interface{}:
+		if data == nil {
+			return nil
+		}
+		return e.guard(reflect.ValueOf(data), func() interface{} {
+			res := make(map[string]interface{}, len(data))
+			for key, value := range data {
+				parentExpand := e.expand
+				e.expand = e.expand[key]
+				res[key] = e.interfaceValue(value)
+				e.expand = parentExpand
+			}
+			return res
+		})
+	}
+
+	return e.value(reflect.ValueOf(data))
+}
+
+func (e *encodeState) arrayValue(v reflect.Value) interface{} {
+	asSlice := make([]interface{}, v.Len())
+	for index := 0; index < v.Len(); index++ {
+		asSlice[index] = e.value(v.Index(index))
+	}
+	return asSlice
+}
+
+// fieldByIndex walks index through embedded structs. It reports false if an
+// embedded pointer along the way is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// mapKey converts a map key to a string like encoding/json does
+func mapKey(k reflect.Value) (string, bool) {
+	if k.Kind() == reflect.String {
+		return k.String(), true
+	}
+
+	if k.CanInterface() {
+		if m, ok := asInterface(k, textMarshalerType); ok {
+			text, err := m.(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return "", false
+			}
+			return string(text), true
+		}
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), true
+	}
+	return "", false
+}
+
+// scalarValue returns the value as is, unless it was reached through an
+// unexported embedded struct in which case it's converted to its basic type
+func scalarValue(v reflect.Value) interface{} {
+	if v.CanInterface() {
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	}
+	return nil
+}
+
+// IsEmpty reports whether a field holding value would be left out by the
+// omitempty option. It's used by code generated with apigen.
+func IsEmpty(value interface{}) bool {
+	return isEmptyValue(reflect.ValueOf(value))
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isQuotableKind reports whether the `,string` option applies to t. Like
+// encoding/json, it's only honored for scalars and pointers to scalars.
+func isQuotableKind(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return true
+	}
+	return false
+}
+
+// quotedValue renders a scalar the way encoding/json does for `,string`
+// fields: its JSON encoding wrapped in a string
+func quotedValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	encoded, err := json.Marshal(scalarValue(v))
+	if err != nil {
+		return nil
+	}
+
+	return string(encoded)
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+)
+
+type benchCarrier struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Email   string   `json:"email,omitempty"`
+	Fleet   int      `json:"fleet"`
+	Active  bool     `json:"active"`
+	Tags    []string `json:"tags,omitempty"`
+	Address struct {
+		City  string `json:"city"`
+		State string `json:"state"`
+	} `json:"address"`
+}
+
+// benchGeneratedCarrier has the same fields as benchCarrier, and the ToApiData
+// method that apigen -type=benchGeneratedCarrier generates for it
+type benchGeneratedCarrier benchCarrier
+
+func (v benchGeneratedCarrier) ToApiData() interface{} {
+	res := make(Fields, 7)
+	res["id"] = v.ID
+	res["name"] = v.Name
+	if v.Email != "" {
+		res["email"] = v.Email
+	}
+	res["fleet"] = v.Fleet
+	res["active"] = v.Active
+	if len(v.Tags) != 0 {
+		res["tags"] = v.Tags
+	}
+	res["address"] = v.Address
+	return res
+}
+
+const benchSliceLength = 10000
+
+func benchCarriers() []benchCarrier {
+	carriers := make([]benchCarrier, benchSliceLength)
+	for i := range carriers {
+		carriers[i] = benchCarrier{
+			ID:     i,
+			Name:   fmt.Sprintf("Carrier %d", i),
+			Fleet:  i % 50,
+			Active: i%2 == 0,
+			Tags:   []string{"hazmat", "interstate"},
+		}
+		if i%3 == 0 {
+			carriers[i].Email = fmt.Sprintf("carrier%d@example.com", i)
+		}
+		carriers[i].Address.City = "Springfield"
+		carriers[i].Address.State = "IL"
+	}
+	return carriers
+}
+
+func clearTypePlans() {
+	typePlans.Range(func(key, value interface{}) bool {
+		typePlans.Delete(key)
+		return true
+	})
+}
+
+// BenchmarkToApiData compares rendering a large slice by reflection with the
+// type plans dropped before every call, with cached plans, and through the
+// methods apigen generates
+func BenchmarkToApiData(b *testing.B) {
+	carriers := benchCarriers()
+
+	generated := make([]benchGeneratedCarrier, len(carriers))
+	for i, carrier := range carriers {
+		generated[i] = benchGeneratedCarrier(carrier)
+	}
+
+	b.Run("reflection", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			clearTypePlans()
+			ToApiData(carriers)
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		ToApiData(carriers)
+		b.ResetTimer()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ToApiData(carriers)
+		}
+	})
+
+	b.Run("generated", func(b *testing.B) {
+		ToApiData(generated)
+		b.ResetTimer()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ToApiData(generated)
+		}
+	})
+}
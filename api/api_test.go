@@ -0,0 +1,247 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToApiDataJSONTags(t *testing.T) {
+	type tagged struct {
+		Renamed     string `json:"renamed"`
+		Untagged    string
+		Skipped     string  `json:"-"`
+		Dash        string  `json:"-,"`
+		Excluded    string  `json:"excluded" api:"exclude"`
+		Omitted     string  `json:"omitted,omitempty"`
+		Kept        string  `json:"kept,omitempty"`
+		OptionsOnly int     `json:",omitempty"`
+		Invalid     string  `json:"bad\"name"`
+		Count       int     `json:"count,string"`
+		Ratio       float64 `json:"ratio,string"`
+		Name        *string `json:"name,string"`
+		NotQuoted   []int   `json:"notQuoted,string"`
+		unexported  string
+	}
+
+	name := "acme"
+	rendered := ToApiData(tagged{
+		Renamed:    "a",
+		Untagged:   "b",
+		Skipped:    "c",
+		Dash:       "d",
+		Excluded:   "e",
+		Kept:       "f",
+		Invalid:    "g",
+		Count:      12,
+		Ratio:      0.5,
+		Name:       &name,
+		NotQuoted:  []int{1},
+		unexported: "h",
+	})
+
+	want := map[string]interface{}{
+		"renamed":   "a",
+		"Untagged":  "b",
+		"-":         "d",
+		"kept":      "f",
+		"Invalid":   "g",
+		"count":     "12",
+		"ratio":     "0.5",
+		"name":      `"acme"`,
+		"notQuoted": []interface{}{1},
+	}
+	if !reflect.DeepEqual(rendered, want) {
+		t.Errorf("got %#v, want %#v", rendered, want)
+	}
+}
+
+func TestToApiDataOmitEmpty(t *testing.T) {
+	type omitted struct {
+		String  string            `json:"string,omitempty"`
+		Int     int               `json:"int,omitempty"`
+		Bool    bool              `json:"bool,omitempty"`
+		Pointer *int              `json:"pointer,omitempty"`
+		Slice   []int             `json:"slice,omitempty"`
+		Map     map[string]string `json:"map,omitempty"`
+		Struct  struct{}          `json:"struct,omitempty"`
+	}
+
+	tests := []struct {
+		name  string
+		value omitted
+		want  map[string]interface{}
+	}{
+		{
+			name:  "zero values",
+			value: omitted{Slice: []int{}, Map: map[string]string{}},
+			want:  map[string]interface{}{"struct": map[string]interface{}{}},
+		},
+		{
+			name:  "set values",
+			value: omitted{String: "a", Int: 1, Bool: true, Slice: []int{1}},
+			want: map[string]interface{}{
+				"string": "a",
+				"int":    1,
+				"bool":   true,
+				"slice":  []interface{}{1},
+				"struct": map[string]interface{}{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rendered := ToApiData(test.value); !reflect.DeepEqual(rendered, test.want) {
+				t.Errorf("got %#v, want %#v", rendered, test.want)
+			}
+		})
+	}
+}
+
+func TestToApiDataKinds(t *testing.T) {
+	type named string
+
+	number := 7
+	var nilPointer *int
+	var nilInterface interface{}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"nil", nil, nil},
+		{"bool", true, true},
+		{"int", -3, -3},
+		{"uint8", uint8(200), uint8(200)},
+		{"float", 1.5, 1.5},
+		{"string", "a", "a"},
+		{"named string", named("a"), named("a")},
+		{"pointer", &number, 7},
+		{"nil pointer", nilPointer, nil},
+		{"nil interface", []interface{}{nilInterface}, []interface{}{nil}},
+		{"bytes", []byte("hi"), "aGk="},
+		{"nil slice", []int(nil), nil},
+		{"array", [2]int{1, 2}, []interface{}{1, 2}},
+		{"string map", map[string]int{"a": 1}, map[string]interface{}{"a": 1}},
+		{"int map", map[int]bool{3: true}, map[string]interface{}{"3": true}},
+		{"unsupported map keys", map[float64]int{1.5: 1}, map[string]interface{}{}},
+		{"nil map", map[string]int(nil), nil},
+		{"chan", make(chan int), nil},
+		{"func", func() {}, nil},
+		{"complex", complex(1, 2), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rendered := ToApiData(test.value); !reflect.DeepEqual(rendered, test.want) {
+				t.Errorf("got %#v, want %#v", rendered, test.want)
+			}
+		})
+	}
+}
+
+type embeddedBase struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type embeddedOther struct {
+	Label string
+	Code  string `json:"code"`
+}
+
+type embeddedLabel struct {
+	Label string
+}
+
+type embeddedDetails struct {
+	Notes string `json:"notes"`
+}
+
+type embeddedUnexported struct {
+	Secret string `json:"secret"`
+}
+
+type embeddedCarrier struct {
+	embeddedBase
+	*embeddedDetails
+	embeddedUnexported
+	Code string `json:"code"`
+}
+
+type embeddedConflict struct {
+	embeddedLabel
+	embeddedOther
+}
+
+type embeddedTagged struct {
+	embeddedBase `json:"base"`
+}
+
+func TestToApiDataEmbedded(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{
+			name: "flattened, shallower field wins",
+			value: embeddedCarrier{
+				embeddedBase:       embeddedBase{ID: 1, Name: "Acme"},
+				embeddedDetails:    &embeddedDetails{Notes: "n"},
+				embeddedUnexported: embeddedUnexported{Secret: "s"},
+				Code:               "AC",
+			},
+			want: map[string]interface{}{"id": 1, "name": "Acme", "notes": "n", "secret": "s", "code": "AC"},
+		},
+		{
+			name:  "nil embedded pointer",
+			value: embeddedCarrier{Code: "AC"},
+			want:  map[string]interface{}{"id": 0, "name": "", "secret": "", "code": "AC"},
+		},
+		{
+			name:  "ambiguous fields are dropped",
+			value: embeddedConflict{embeddedLabel{Label: "a"}, embeddedOther{Label: "b", Code: "c"}},
+			want:  map[string]interface{}{"code": "c"},
+		},
+		{
+			name:  "tagged embedded struct is nested",
+			value: embeddedTagged{embeddedBase{ID: 1, Name: "a"}},
+			want:  map[string]interface{}{"base": map[string]interface{}{"id": 1, "name": "a"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rendered := ToApiData(test.value); !reflect.DeepEqual(rendered, test.want) {
+				t.Errorf("got %#v, want %#v", rendered, test.want)
+			}
+		})
+	}
+}
+
+type cyclicNode struct {
+	Name     string        `json:"name"`
+	Next     *cyclicNode   `json:"next"`
+	Children []*cyclicNode `json:"children,omitempty"`
+}
+
+func TestToApiDataCycles(t *testing.T) {
+	node := &cyclicNode{Name: "a"}
+	node.Next = node
+
+	want := map[string]interface{}{"name": "a", "next": nil}
+	if rendered := ToApiData(node); !reflect.DeepEqual(rendered, want) {
+		t.Errorf("got %#v, want %#v", rendered, want)
+	}
+
+	// The same value twice side by side isn't a cycle
+	leaf := &cyclicNode{Name: "leaf"}
+	parent := cyclicNode{Name: "parent", Children: []*cyclicNode{leaf, leaf}}
+	rendered := ToApiData(parent).(map[string]interface{})
+	children := rendered["children"].([]interface{})
+	if children[0] == nil || children[1] == nil {
+		t.Errorf("got children %#v, want both rendered", children)
+	}
+}
@@ -0,0 +1,239 @@
+// Command apigen generates ToApiData methods for struct types so that
+// api.ToApiData can render them without walking their fields with reflection.
+// Nested values are still rendered by the api package.
+//
+// Install it with
+//
+//	go install github.com/williamhaley/go/api/cmd/apigen@latest
+//
+// or, from a checkout, by running go install ./cmd/apigen in the api
+// directory. Then add a directive next to the types and run go generate:
+//
+//	//go:generate apigen -type=Carrier,Crash
+//
+// The generated methods return api.Fields, so the generated file imports the
+// api package, and fields without a json tag name are renamed by the naming
+// strategy in use and described in OpenAPI documents like any struct. Types
+// with fields that depend on the viewer, such as `api:"roles=..."` or
+// `api:"expandable"`, and types with embedded fields aren't supported and
+// should be left to reflection.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct type names; required")
+	output    = flag.String("output", "", "output file name; default <first type>_apidata.go")
+	apiImport = flag.String("api", "github.com/williamhaley/go/api", "import path of the api package")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("apigen: ")
+	flag.Parse()
+
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	types := strings.Split(*typeNames, ",")
+
+	packageName, structs, err := parseStructs(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	for _, typeName := range types {
+		structType, ok := structs[typeName]
+		if !ok {
+			log.Fatalf("struct type %s not found in %s", typeName, dir)
+		}
+
+		if err := generateMethod(&body, typeName, structType); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by apigen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\n", packageName)
+	fmt.Fprintf(&src, "import %q\n\n", *apiImport)
+	src.Write(body.Bytes())
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		log.Fatalf("formatting generated code: %v", err)
+	}
+
+	outputName := *output
+	if outputName == "" {
+		outputName = strings.ToLower(types[0]) + "_apidata.go"
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, outputName), formatted, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseStructs(dir string) (string, map[string]*ast.StructType, error) {
+	fset := token.NewFileSet()
+	packages, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	packageName := ""
+	structs := map[string]*ast.StructType{}
+
+	for name, pkg := range packages {
+		packageName = name
+
+		for _, file := range pkg.Files {
+			ast.Inspect(file, func(node ast.Node) bool {
+				spec, ok := node.(*ast.TypeSpec)
+				if !ok {
+					return true
+				}
+				if structType, ok := spec.Type.(*ast.StructType); ok {
+					structs[spec.Name.Name] = structType
+				}
+				return false
+			})
+		}
+	}
+
+	if packageName == "" {
+		return "", nil, fmt.Errorf("no Go package found in %s", dir)
+	}
+
+	return packageName, structs, nil
+}
+
+// generateMethod writes the ToApiData method for a type
+func generateMethod(w *bytes.Buffer, typeName string, structType *ast.StructType) error {
+	var assignments bytes.Buffer
+	count := 0
+
+	for _, f := range structType.Fields.List {
+		if len(f.Names) == 0 {
+			return fmt.Errorf("%s: embedded fields aren't supported", typeName)
+		}
+
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			unquoted, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return fmt.Errorf("%s: invalid struct tag %s", typeName, f.Tag.Value)
+			}
+			tag = reflect.StructTag(unquoted)
+		}
+
+		apiTag := tag.Get("api")
+		if apiTag == "exclude" {
+			continue
+		}
+		if apiTag != "" {
+			return fmt.Errorf("%s: api tag %q depends on the viewer and isn't supported", typeName, apiTag)
+		}
+
+		jsonTag := tag.Get("json")
+		if jsonTag == "-" {
+			continue
+		}
+
+		jsonName, options := jsonTag, ""
+		if idx := strings.Index(jsonTag, ","); idx != -1 {
+			jsonName, options = jsonTag[:idx], jsonTag[idx+1:]
+		}
+		omitEmpty := hasOption(options, "omitempty")
+		if hasOption(options, "string") {
+			return fmt.Errorf("%s: the json string option isn't supported", typeName)
+		}
+
+		for _, name := range f.Names {
+			if !name.IsExported() {
+				continue
+			}
+
+			key := name.Name
+			if jsonName != "" {
+				key = jsonName
+			}
+
+			count++
+			assignment := fmt.Sprintf("res[%q] = v.%s\n", key, name.Name)
+			if omitEmpty {
+				condition := notEmptyCondition(f.Type, "v."+name.Name)
+				assignment = fmt.Sprintf("if %s {\n%s}\n", condition, assignment)
+			}
+			assignments.WriteString(assignment)
+		}
+	}
+
+	fmt.Fprintf(w, "// ToApiData implements api.ApiMarshaler\n")
+	fmt.Fprintf(w, "func (v %s) ToApiData() interface{} {\n", typeName)
+	fmt.Fprintf(w, "res := make(api.Fields, %d)\n", count)
+	w.Write(assignments.Bytes())
+	fmt.Fprintf(w, "return res\n}\n\n")
+
+	return nil
+}
+
+func hasOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// notEmptyCondition returns an expression that is true when the omitempty
+// option keeps the field. Types whose kind isn't known from the syntax alone
+// are checked at run time.
+func notEmptyCondition(typ ast.Expr, expr string) string {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return expr + ` != ""`
+		case "bool":
+			return expr
+		case "int", "int8", "int16", "int32", "int64",
+			"uint", "uint8", "uint16", "uint32", "uint64", "uintptr",
+			"float32", "float64", "byte", "rune":
+			return expr + " != 0"
+		}
+	case *ast.StarExpr, *ast.InterfaceType:
+		return expr + " != nil"
+	case *ast.MapType:
+		return "len(" + expr + ") != 0"
+	case *ast.ArrayType:
+		return "len(" + expr + ") != 0"
+	}
+
+	return "!api.IsEmpty(" + expr + ")"
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+// parseStruct returns the struct type declared in src
+func parseStruct(t *testing.T, src string) *ast.StructType {
+	t.Helper()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.StructType)
+}
+
+func TestGenerateMethod(t *testing.T) {
+	structType := parseStruct(t, "type Carrier struct {\n"+
+		"ID int `json:\"id\"`\n"+
+		"Name string\n"+
+		"Email string `json:\"email,omitempty\"`\n"+
+		"Tags []string `json:\",omitempty\"`\n"+
+		"Owner *Owner `json:\"owner,omitempty\"`\n"+
+		"Address Address `json:\"address,omitempty\"`\n"+
+		"Secret string `json:\"-\"`\n"+
+		"Internal string `api:\"exclude\"`\n"+
+		"notes string\n"+
+		"}")
+
+	var body bytes.Buffer
+	if err := generateMethod(&body, "Carrier", structType); err != nil {
+		t.Fatal(err)
+	}
+
+	formatted, err := format.Source(append([]byte("package p\n\n"), body.Bytes()...))
+	if err != nil {
+		t.Fatalf("generated invalid code: %v\n%s", err, body.String())
+	}
+	generated := string(formatted)
+
+	for _, want := range []string{
+		`func (v Carrier) ToApiData() interface{} {`,
+		`res := make(api.Fields, 6)`,
+		`res["id"] = v.ID`,
+		`res["Name"] = v.Name`,
+		`if v.Email != "" {`,
+		`if len(v.Tags) != 0 {`,
+		`if v.Owner != nil {`,
+		`if !api.IsEmpty(v.Address) {`,
+	} {
+		if !strings.Contains(generated, want) {
+			t.Errorf("generated code doesn't contain %q:\n%s", want, generated)
+		}
+	}
+	for _, unwanted := range []string{"Secret", "Internal", "notes"} {
+		if strings.Contains(generated, unwanted) {
+			t.Errorf("generated code renders %s:\n%s", unwanted, generated)
+		}
+	}
+}
+
+func TestGenerateMethodRejectsUnsupportedFields(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"embedded", "type T struct {\nBase\n}"},
+		{"viewer-dependent", "type T struct {\nA string `api:\"roles=admin\"`\n}"},
+		{"string option", "type T struct {\nA int `json:\"a,string\"`\n}"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body bytes.Buffer
+			if err := generateMethod(&body, "T", parseStruct(t, test.src)); err == nil {
+				t.Errorf("generated %s", body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package api
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldError describes a problem with one field of incoming data. Field is
+// the dotted path of the field, using its rendered name.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is every problem found with incoming data
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fmt.Sprintf("%s %s", fieldError.Field, fieldError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// FromApiData is the inverse of ToApiData. It decodes a JSON object into
+// destination, which must be a pointer to a struct, using the same field
+// names. Fields that are excluded, restricted to roles, tagged
+// `api:"readonly"` or redacted for the viewer can't be written. Fields are
+// then checked against their validate tags, e.g.
+// `validate:"required;min=1;max=100;enum=a,b;regex=^[a-z]+$"`. The regex
+// directive takes the rest of the tag, so it goes last. Problems with fields
+// are returned together as ValidationErrors, in which case destination is
+// left untouched.
+func FromApiData(data []byte, destination interface{}) error {
+	return FromApiDataFor(data, destination, nil)
+}
+
+// FromApiDataFor is FromApiData for fields writable by viewer
+func FromApiDataFor(data []byte, destination interface{}, viewer Viewer) error {
+	return FromApiDataWithOptions(data, destination, Options{Viewer: viewer})
+}
+
+// FromApiDataWithOptions is FromApiDataFor with a naming strategy, so it reads
+// the names ToApiDataWithOptions renders. The projection is ignored.
+func FromApiDataWithOptions(data []byte, destination interface{}, options Options) error {
+	return decodeApiData(data, destination, options, false)
+}
+
+// PatchFromApiData applies a partial update. Only fields present in data are
+// written and validated, and nested objects are merged into the existing
+// values rather than replacing them.
+func PatchFromApiData(data []byte, destination interface{}) error {
+	return PatchFromApiDataFor(data, destination, nil)
+}
+
+// PatchFromApiDataFor is PatchFromApiData for fields writable by viewer
+func PatchFromApiDataFor(data []byte, destination interface{}, viewer Viewer) error {
+	return PatchFromApiDataWithOptions(data, destination, Options{Viewer: viewer})
+}
+
+// PatchFromApiDataWithOptions is PatchFromApiDataFor with a naming strategy,
+// see FromApiDataWithOptions
+func PatchFromApiDataWithOptions(data []byte, destination interface{}, options Options) error {
+	return decodeApiData(data, destination, options, true)
+}
+
+type decodeState struct {
+	*encodeState
+	errors ValidationErrors
+
+	// owned holds the embedded structs allocated for the working copy, which
+	// can be written to without changing the caller's value
+	owned map[uintptr]bool
+}
+
+func decodeApiData(data []byte, destination interface{}, options Options, partial bool) error {
+	v := reflect.ValueOf(destination)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("destination must be a non-nil pointer to a struct")
+	}
+
+	object, err := decodeObject(data)
+	if err != nil {
+		return err
+	}
+
+	d := &decodeState{
+		encodeState: newEncodeState(Options{Viewer: options.Viewer, Naming: options.Naming}),
+		owned:       map[uintptr]bool{},
+	}
+
+	// Work on a copy so nothing changes unless the whole update is valid.
+	// The copy is shallow, so embedded pointers are copied as they're written.
+	working := reflect.New(v.Elem().Type())
+	working.Elem().Set(v.Elem())
+
+	d.object(working.Elem(), object, "", partial)
+	if len(d.errors) > 0 {
+		sort.SliceStable(d.errors, func(i, j int) bool {
+			return d.errors[i].Field < d.errors[j].Field
+		})
+		return d.errors
+	}
+
+	v.Elem().Set(working.Elem())
+
+	return nil
+}
+
+func decodeObject(data []byte) (map[string]json.RawMessage, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	if object == nil {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+	return object, nil
+}
+
+func (d *decodeState) fail(path, message string) {
+	d.errors = append(d.errors, FieldError{Field: path, Message: message})
+}
+
+// object writes the supplied fields of a JSON object into struct v. Unless
+// partial, fields that weren't supplied are validated too.
+func (d *decodeState) object(v reflect.Value, object map[string]json.RawMessage, prefix string, partial bool) {
+	fields := map[string]field{}
+	for _, f := range cachedTypeFields(v.Type()) {
+		if d.canView(f.roles) {
+			fields[d.fieldName(f)] = f
+		}
+	}
+
+	for name, raw := range object {
+		path := prefix + name
+
+		f, ok := fields[name]
+		if !ok {
+			d.fail(path, "is not a known field")
+			continue
+		}
+
+		// Viewers can't write values they can only see redacted
+		if f.readOnly || !d.canUnmask(f.redaction) {
+			d.fail(path, "is read-only")
+			continue
+		}
+
+		if f.quoted && !bytes.Equal(raw, []byte("null")) {
+			var unquoted string
+			if err := json.Unmarshal(raw, &unquoted); err != nil {
+				d.fail(path, "must be a string")
+				continue
+			}
+			raw = json.RawMessage(unquoted)
+		}
+
+		fv, ok := d.fieldByIndexAlloc(v, f.index)
+		if !ok {
+			// Like encoding/json, pointers to unexported embedded structs
+			// can't be set
+			d.fail(path, "is read-only")
+			continue
+		}
+		if !d.value(fv, raw, path, partial) {
+			continue
+		}
+
+		d.validate(fv, f, path, true)
+	}
+
+	if partial {
+		return
+	}
+
+	for _, f := range fields {
+		name := d.fieldName(f)
+		if _, supplied := object[name]; supplied {
+			continue
+		}
+
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok {
+			fv = reflect.Zero(f.typ)
+		}
+
+		d.validate(fv, f, prefix+name, false)
+	}
+}
+
+// value decodes raw into fv, reporting whether it succeeded. Objects and
+// arrays of structs are decoded field by field so the same rules apply to
+// them, and when merging, objects are patched into the existing value.
+func (d *decodeState) value(fv reflect.Value, raw json.RawMessage, path string, merge bool) bool {
+	trimmed := bytes.TrimSpace(raw)
+
+	if isMergeable(fv.Type()) && bytes.HasPrefix(trimmed, []byte("{")) {
+		object, err := decodeObject(raw)
+		if err != nil {
+			d.fail(path, "must be an object")
+			return false
+		}
+
+		target := reflect.New(indirectType(fv.Type()))
+		if merge {
+			// Merge into a copy so the value being patched is never changed
+			if fv.Kind() != reflect.Ptr {
+				target.Elem().Set(fv)
+			} else if !fv.IsNil() {
+				target.Elem().Set(fv.Elem())
+			}
+		}
+
+		errorCount := len(d.errors)
+		d.object(target.Elem(), object, path+".", merge)
+
+		if fv.Kind() == reflect.Ptr {
+			fv.Set(target)
+		} else {
+			fv.Set(target.Elem())
+		}
+
+		return len(d.errors) == errorCount
+	}
+
+	if fv.Kind() == reflect.Slice && isMergeable(fv.Type().Elem()) && bytes.HasPrefix(trimmed, []byte("[")) {
+		var elements []json.RawMessage
+		if err := json.Unmarshal(raw, &elements); err != nil {
+			d.fail(path, "must be an array")
+			return false
+		}
+
+		errorCount := len(d.errors)
+
+		slice := reflect.MakeSlice(fv.Type(), len(elements), len(elements))
+		for i, element := range elements {
+			d.value(slice.Index(i), element, fmt.Sprintf("%s.%d", path, i), false)
+		}
+		fv.Set(slice)
+
+		return len(d.errors) == errorCount
+	}
+
+	// Start from scratch so existing maps and slices aren't merged into
+	decoded := reflect.New(fv.Type())
+	if err := json.Unmarshal(raw, decoded.Interface()); err != nil {
+		d.fail(path, typeMessage(fv.Type()))
+		return false
+	}
+	fv.Set(decoded.Elem())
+
+	return true
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// isMergeable reports whether a field's existing value is patched field by
+// field rather than replaced
+func isMergeable(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || hasCustomRepresentation(t) {
+		return false
+	}
+
+	return !reflect.PtrTo(t).Implements(jsonUnmarshalerType) && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+func typeMessage(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if hasCustomRepresentation(t) {
+		return "is not valid"
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "must be a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "must be a number"
+	case reflect.String:
+		return "must be a string"
+	case reflect.Struct, reflect.Map:
+		return "must be an object"
+	case reflect.Slice, reflect.Array:
+		return "must be an array"
+	}
+	return "is not valid"
+}
+
+// fieldByIndexAlloc is fieldByIndex for writing. Embedded pointers are
+// replaced with a copy of the struct they point to, or a new one when nil, so
+// the value being decoded into never shares them with the caller. It reports
+// false when such a pointer can't be set.
+func (d *decodeState) fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() || !d.owned[v.Pointer()] {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				copied := reflect.New(v.Type().Elem())
+				if !v.IsNil() {
+					copied.Elem().Set(v.Elem())
+				}
+				v.Set(copied)
+				d.owned[copied.Pointer()] = true
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type decodeAddress struct {
+	City  string `json:"city" validate:"required"`
+	State string `json:"state"`
+}
+
+// DecodeDetails is exported so it can be allocated when embedded by pointer
+type DecodeDetails struct {
+	Notes string `json:"notes"`
+}
+
+type decodeTruck struct {
+	Plate string `json:"plate" validate:"required"`
+}
+
+type decodeCarrier struct {
+	*DecodeDetails
+	ID      int            `json:"id" api:"readonly"`
+	Name    string         `json:"name" validate:"required"`
+	Fleet   int            `json:"fleet,string"`
+	Address decodeAddress  `json:"address"`
+	Billing *decodeAddress `json:"billing"`
+	Trucks  []decodeTruck  `json:"trucks"`
+	Tags    []string       `json:"tags"`
+	Secret  string         `json:"secret" api:"roles=admin"`
+	Skipped string         `json:"-"`
+}
+
+// decodeErrorFields returns the fields named by a ValidationErrors
+func decodeErrorFields(t *testing.T, err error) map[string]string {
+	t.Helper()
+
+	var validationErrors ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		t.Fatalf("got %v, want ValidationErrors", err)
+	}
+
+	fields := map[string]string{}
+	for _, fieldError := range validationErrors {
+		fields[fieldError.Field] = fieldError.Message
+	}
+	return fields
+}
+
+func TestFromApiData(t *testing.T) {
+	var carrier decodeCarrier
+	err := FromApiData([]byte(`{
+		"name": "Acme",
+		"fleet": "12",
+		"notes": "n",
+		"address": {"city": "Springfield"},
+		"billing": {"city": "Chicago", "state": "IL"},
+		"trucks": [{"plate": "A1"}],
+		"tags": ["hazmat"]
+	}`), &carrier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := decodeCarrier{
+		DecodeDetails: &DecodeDetails{Notes: "n"},
+		Name:          "Acme",
+		Fleet:         12,
+		Address:       decodeAddress{City: "Springfield"},
+		Billing:       &decodeAddress{City: "Chicago", State: "IL"},
+		Trucks:        []decodeTruck{{Plate: "A1"}},
+		Tags:          []string{"hazmat"},
+	}
+	if !reflect.DeepEqual(carrier, want) {
+		t.Errorf("got %+v, want %+v", carrier, want)
+	}
+}
+
+func TestFromApiDataErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		viewer Viewer
+		want   map[string]string
+	}{
+		{
+			name: "unknown and hidden fields",
+			data: `{"name": "a", "owner": "x", "Skipped": "y", "secret": "z"}`,
+			want: map[string]string{
+				"owner":   "is not a known field",
+				"Skipped": "is not a known field",
+				"secret":  "is not a known field",
+			},
+		},
+		{
+			name: "read-only",
+			data: `{"name": "a", "id": 3}`,
+			want: map[string]string{"id": "is read-only"},
+		},
+		{
+			name: "types",
+			data: `{"name": 1, "fleet": 12, "address": [], "tags": "a"}`,
+			want: map[string]string{
+				"name":    "must be a string",
+				"fleet":   "must be a string",
+				"address": "must be an object",
+				"tags":    "must be an array",
+			},
+		},
+		{
+			name: "nested paths",
+			data: `{"name": "a", "address": {"state": "IL"}, "trucks": [{"plate": "A1"}, {}]}`,
+			want: map[string]string{
+				"address.city":   "is required",
+				"trucks.1.plate": "is required",
+			},
+		},
+		{
+			name: "missing required field",
+			data: `{}`,
+			want: map[string]string{"name": "is required"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := decodeCarrier{Name: "original"}
+			carrier := original
+
+			err := FromApiDataFor([]byte(test.data), &carrier, test.viewer)
+			fields := decodeErrorFields(t, err)
+			for field, message := range test.want {
+				if fields[field] != message {
+					t.Errorf("%s: got %q, want %q", field, fields[field], message)
+				}
+			}
+			if len(fields) != len(test.want) {
+				t.Errorf("got errors %v, want %v", fields, test.want)
+			}
+
+			if !reflect.DeepEqual(carrier, original) {
+				t.Errorf("destination changed to %+v", carrier)
+			}
+		})
+	}
+}
+
+func TestFromApiDataRolesAndInput(t *testing.T) {
+	var carrier decodeCarrier
+	if err := FromApiDataFor([]byte(`{"name": "a", "secret": "s"}`), &carrier, testViewer{"admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if carrier.Secret != "s" {
+		t.Errorf("got secret %q, want s", carrier.Secret)
+	}
+
+	for _, data := range []string{`[]`, `null`, `"a"`, `{`} {
+		if err := FromApiData([]byte(data), &carrier); err == nil {
+			t.Errorf("decoded %s", data)
+		}
+	}
+
+	for _, destination := range []interface{}{carrier, (*decodeCarrier)(nil), new(int)} {
+		if err := FromApiData([]byte(`{}`), destination); err == nil {
+			t.Errorf("decoded into %T", destination)
+		}
+	}
+}
+
+func TestPatchFromApiData(t *testing.T) {
+	details := &DecodeDetails{Notes: "old"}
+	carrier := decodeCarrier{
+		DecodeDetails: details,
+		Name:          "Acme",
+		Address:       decodeAddress{City: "Springfield", State: "IL"},
+		Billing:       &decodeAddress{City: "Chicago", State: "IL"},
+		Tags:          []string{"a", "b"},
+	}
+	billing := carrier.Billing
+
+	err := PatchFromApiData([]byte(`{
+		"notes": "new",
+		"address": {"state": "WI"},
+		"billing": {"city": "Madison"},
+		"tags": ["c"]
+	}`), &carrier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := decodeCarrier{
+		DecodeDetails: &DecodeDetails{Notes: "new"},
+		Name:          "Acme",
+		Address:       decodeAddress{City: "Springfield", State: "WI"},
+		Billing:       &decodeAddress{City: "Madison", State: "IL"},
+		Tags:          []string{"c"},
+	}
+	if !reflect.DeepEqual(carrier, want) {
+		t.Errorf("got %+v, want %+v", carrier, want)
+	}
+
+	// Values shared with the caller aren't written through
+	if details.Notes != "old" || billing.City != "Chicago" {
+		t.Errorf("patched shared values: %+v and %+v", details, billing)
+	}
+
+	// Only supplied fields are validated, but they're validated in full
+	if err := PatchFromApiData([]byte(`{"address": {"city": ""}}`), &carrier); err == nil {
+		t.Error("patched a required field to empty")
+	}
+	if err := PatchFromApiData([]byte(`{"fleet": "3"}`), &decodeCarrier{}); err != nil {
+		t.Errorf("patch without the required name: %v", err)
+	}
+}
@@ -0,0 +1,472 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Resources in JSON:API and HAL documents are described with api tags. The
+// ID field is tagged `api:"id;type=carriers"`, and fields holding related
+// resources are tagged `api:"relationship"`. The type defaults to the
+// kebab-cased struct name. Links are built as <baseURL>/<type>/<id>.
+
+type resourceInfo struct {
+	typeName      string
+	id            field
+	relationships []field
+}
+
+// JSONAPIDocument renders data, a struct or a slice of structs, as a JSON:API
+// document. Related resources that are rendered, e.g. because they were
+// expanded, are added to the included resources.
+func JSONAPIDocument(data interface{}, baseURL string, options Options) (map[string]interface{}, error) {
+	d := &documentState{
+		encodeState: newEncodeState(options),
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		options:     options,
+		seen:        map[string]struct{}{},
+	}
+
+	v, t, isCollection, err := d.resources(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Primary resources aren't repeated in the included resources
+	if err := d.markPrimary(v); err != nil {
+		return nil, err
+	}
+
+	document := map[string]interface{}{}
+
+	if isCollection {
+		info, err := d.resourceInfo(t)
+		if err != nil {
+			return nil, err
+		}
+
+		resources := make([]interface{}, 0)
+		if v.IsValid() {
+			for i := 0; i < v.Len(); i++ {
+				resource, err := d.jsonAPIResource(v.Index(i))
+				if err != nil {
+					return nil, err
+				}
+				resources = append(resources, resource)
+			}
+		}
+
+		document["data"] = resources
+		document["links"] = map[string]interface{}{"self": d.baseURL + "/" + info.typeName}
+	} else {
+		resource, err := d.jsonAPIResource(v)
+		if err != nil {
+			return nil, err
+		}
+
+		document["data"] = resource
+		if resource, ok := resource.(map[string]interface{}); ok {
+			document["links"] = resource["links"]
+		}
+	}
+
+	if len(d.included) > 0 {
+		document["included"] = d.included
+	}
+
+	return document, nil
+}
+
+// HALDocument renders data, a struct or a slice of structs, as a HAL
+// document. Related resources that are rendered are embedded.
+func HALDocument(data interface{}, baseURL string, options Options) (map[string]interface{}, error) {
+	d := &documentState{
+		encodeState: newEncodeState(options),
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		options:     options,
+	}
+
+	v, t, isCollection, err := d.resources(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isCollection {
+		resource, err := d.halResource(v, d.fields())
+		if err != nil {
+			return nil, err
+		}
+		if resource == nil {
+			return nil, nil
+		}
+		return resource.(map[string]interface{}), nil
+	}
+
+	info, err := d.resourceInfo(t)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]interface{}, 0)
+	if v.IsValid() {
+		for i := 0; i < v.Len(); i++ {
+			resource, err := d.halResource(v.Index(i), d.fields())
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, resource)
+		}
+	}
+
+	return map[string]interface{}{
+		"_links": map[string]interface{}{
+			"self": halLink(d.baseURL + "/" + info.typeName),
+		},
+		"_embedded": map[string]interface{}{
+			info.typeName: resources,
+		},
+		"count": len(resources),
+	}, nil
+}
+
+type documentState struct {
+	*encodeState
+	baseURL string
+	options Options
+
+	// JSON:API included resources, and the type/id pairs already in them
+	included []interface{}
+	seen     map[string]struct{}
+}
+
+func (d *documentState) fields() fieldTree {
+	if d.options.Projection == nil {
+		return nil
+	}
+	return d.options.Projection.fields
+}
+
+// resources unwraps data into a single struct or a collection of structs and
+// checks the projection against the resource type
+func (d *documentState) resources(data interface{}) (reflect.Value, reflect.Type, bool, error) {
+	v := reflect.ValueOf(data)
+	t := reflect.TypeOf(data)
+	if t == nil {
+		return reflect.Value{}, nil, false, fmt.Errorf("no data to render")
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		if v.IsValid() {
+			if v.IsNil() {
+				v = reflect.Value{}
+			} else {
+				v = v.Elem()
+			}
+		}
+	}
+
+	isCollection := t.Kind() == reflect.Slice || t.Kind() == reflect.Array
+	if isCollection {
+		if v.IsValid() && v.Kind() == reflect.Slice && v.IsNil() {
+			v = reflect.Value{}
+		}
+		t = indirectType(t.Elem())
+	}
+
+	if t.Kind() != reflect.Struct {
+		return reflect.Value{}, nil, false, fmt.Errorf("%s is not a struct", t)
+	}
+
+	if d.options.Projection != nil {
+		var unknown []string
+		d.validatePaths(t, d.options.Projection.fields, "", &unknown)
+		d.validatePaths(t, d.options.Projection.expand, "", &unknown)
+		if len(unknown) > 0 {
+			return reflect.Value{}, nil, false, &UnknownFieldsError{Fields: unknown}
+		}
+	}
+
+	return v, t, isCollection, nil
+}
+
+func (d *documentState) resourceInfo(t reflect.Type) (*resourceInfo, error) {
+	t = indirectType(t)
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", t)
+	}
+
+	info := &resourceInfo{}
+	hasID := false
+
+	for _, f := range cachedTypeFields(t) {
+		if f.resourceID {
+			info.id = f
+			info.typeName = f.resourceType
+			hasID = true
+		}
+		if f.relationship {
+			info.relationships = append(info.relationships, f)
+		}
+	}
+
+	if !hasID {
+		return nil, fmt.Errorf("%s has no field tagged api:\"id\"", t)
+	}
+
+	if info.typeName == "" {
+		info.typeName = KebabCase(t.Name())
+	}
+
+	return info, nil
+}
+
+// identify returns the type and ID of resource v, which must be a struct
+func (d *documentState) identify(v reflect.Value) (*resourceInfo, string, error) {
+	info, err := d.resourceInfo(v.Type())
+	if err != nil {
+		return nil, "", err
+	}
+
+	idValue, ok := fieldByIndex(v, info.id.index)
+	if !ok {
+		return nil, "", fmt.Errorf("%s has no ID", v.Type())
+	}
+
+	return info, fmt.Sprint(d.value(idValue)), nil
+}
+
+func (d *documentState) selfLink(info *resourceInfo, id string) string {
+	return d.baseURL + "/" + info.typeName + "/" + url.PathEscape(id)
+}
+
+func (d *documentState) jsonAPIResource(v reflect.Value) (interface{}, error) {
+	v = indirectValue(v)
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	info, id, err := d.identify(v)
+	if err != nil {
+		return nil, err
+	}
+
+	self := d.selfLink(info, id)
+
+	full := d.structValue(v).(map[string]interface{})
+	attributes := project(full, d.fields()).(map[string]interface{})
+	delete(attributes, d.fieldName(info.id))
+
+	relationships := map[string]interface{}{}
+
+	for _, f := range info.relationships {
+		name := d.fieldName(f)
+
+		if _, selected := d.fields()[name]; len(d.fields()) > 0 && !selected {
+			continue
+		}
+		_, rendered := full[name]
+		delete(attributes, name)
+
+		relationship := map[string]interface{}{
+			"links": map[string]interface{}{
+				"self":    self + "/relationships/" + name,
+				"related": self + "/" + name,
+			},
+		}
+
+		// Data is only included when the field itself would be rendered
+		if rendered {
+			fv, _ := fieldByIndex(v, f.index)
+
+			parentExpand := d.expand
+			d.expand = d.expand[name]
+			identifiers, err := d.relationshipData(fv)
+			d.expand = parentExpand
+			if err != nil {
+				return nil, err
+			}
+
+			relationship["data"] = identifiers
+		}
+
+		relationships[name] = relationship
+	}
+
+	resource := map[string]interface{}{
+		"type":       info.typeName,
+		"id":         id,
+		"attributes": attributes,
+		"links": map[string]interface{}{
+			"self": self,
+		},
+	}
+	if len(relationships) > 0 {
+		resource["relationships"] = relationships
+	}
+
+	return resource, nil
+}
+
+// markPrimary adds the type/id pairs of the primary resources in v to the
+// resources already in the document
+func (d *documentState) markPrimary(v reflect.Value) error {
+	v = indirectValue(v)
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		for i := 0; i < v.Len(); i++ {
+			if err := d.markPrimary(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	info, id, err := d.identify(v)
+	if err != nil {
+		return err
+	}
+	d.seen[info.typeName+"/"+id] = struct{}{}
+
+	return nil
+}
+
+// relationshipData returns resource identifiers for the related resources in
+// v, adding the resources themselves to the included resources
+func (d *documentState) relationshipData(v reflect.Value) (interface{}, error) {
+	v = indirectValue(v)
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		identifiers := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			identifier, err := d.relationshipData(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			if identifier != nil {
+				identifiers = append(identifiers, identifier)
+			}
+		}
+		return identifiers, nil
+	}
+
+	info, id, err := d.identify(v)
+	if err != nil {
+		return nil, err
+	}
+
+	key := info.typeName + "/" + id
+	if _, ok := d.seen[key]; !ok {
+		d.seen[key] = struct{}{}
+
+		// Included resources are rendered in full
+		parentFields := d.options.Projection
+		d.options.Projection = nil
+		resource, err := d.jsonAPIResource(v)
+		d.options.Projection = parentFields
+		if err != nil {
+			return nil, err
+		}
+
+		d.included = append(d.included, resource)
+	}
+
+	return map[string]interface{}{
+		"type": info.typeName,
+		"id":   id,
+	}, nil
+}
+
+func (d *documentState) halResource(v reflect.Value, fields fieldTree) (interface{}, error) {
+	v = indirectValue(v)
+	if !v.IsValid() {
+		return nil, nil
+	}
+
+	if v.Kind() == reflect.Slice || v.Kind() == reflect.Array {
+		resources := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			resource, err := d.halResource(v.Index(i), fields)
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, resource)
+		}
+		return resources, nil
+	}
+
+	if v.Kind() != reflect.Struct {
+		return d.value(v), nil
+	}
+
+	full := d.structValue(v).(map[string]interface{})
+	resource := project(full, fields).(map[string]interface{})
+
+	// Resources without an ID are embedded without links
+	info, id, err := d.identify(v)
+	if err != nil {
+		return resource, nil
+	}
+
+	self := d.selfLink(info, id)
+	links := map[string]interface{}{
+		"self": halLink(self),
+	}
+	embedded := map[string]interface{}{}
+
+	for _, f := range info.relationships {
+		name := d.fieldName(f)
+
+		subfields, selected := fields[name]
+		if len(fields) > 0 && !selected {
+			continue
+		}
+		_, rendered := full[name]
+		delete(resource, name)
+
+		links[name] = halLink(self + "/" + name)
+
+		if rendered {
+			fv, _ := fieldByIndex(v, f.index)
+
+			parentExpand := d.expand
+			d.expand = d.expand[name]
+			related, err := d.halResource(fv, subfields)
+			d.expand = parentExpand
+			if err != nil {
+				return nil, err
+			}
+
+			embedded[name] = related
+		}
+	}
+
+	resource["_links"] = links
+	if len(embedded) > 0 {
+		resource["_embedded"] = embedded
+	}
+
+	return resource, nil
+}
+
+func halLink(href string) map[string]interface{} {
+	return map[string]interface{}{"href": href}
+}
+
+func indirectValue(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
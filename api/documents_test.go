@@ -0,0 +1,161 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+type documentDriver struct {
+	ID   int    `json:"id" api:"id;type=drivers"`
+	Name string `json:"name"`
+}
+
+type documentCarrier struct {
+	ID       int               `json:"id" api:"id"`
+	Name     string            `json:"name"`
+	Drivers  []*documentDriver `json:"drivers" api:"relationship"`
+	Partners []documentCarrier `json:"partners,omitempty" api:"relationship"`
+}
+
+func TestJSONAPIDocument(t *testing.T) {
+	driver := &documentDriver{ID: 7, Name: "Pat"}
+	carrier := documentCarrier{ID: 1, Name: "Acme", Drivers: []*documentDriver{driver, driver}}
+
+	got, err := JSONAPIDocument(carrier, "https://example.com/", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       "document-carrier",
+			"id":         "1",
+			"attributes": map[string]interface{}{"name": "Acme"},
+			"links":      map[string]interface{}{"self": "https://example.com/document-carrier/1"},
+			"relationships": map[string]interface{}{
+				"drivers": map[string]interface{}{
+					"links": map[string]interface{}{
+						"self":    "https://example.com/document-carrier/1/relationships/drivers",
+						"related": "https://example.com/document-carrier/1/drivers",
+					},
+					"data": []interface{}{
+						map[string]interface{}{"type": "drivers", "id": "7"},
+						map[string]interface{}{"type": "drivers", "id": "7"},
+					},
+				},
+				"partners": map[string]interface{}{
+					"links": map[string]interface{}{
+						"self":    "https://example.com/document-carrier/1/relationships/partners",
+						"related": "https://example.com/document-carrier/1/partners",
+					},
+				},
+			},
+		},
+		"links": map[string]interface{}{"self": "https://example.com/document-carrier/1"},
+		"included": []interface{}{
+			map[string]interface{}{
+				"type":       "drivers",
+				"id":         "7",
+				"attributes": map[string]interface{}{"name": "Pat"},
+				"links":      map[string]interface{}{"self": "https://example.com/drivers/7"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestJSONAPIDocumentPrimaryNotIncluded(t *testing.T) {
+	acme := documentCarrier{ID: 1, Name: "Acme"}
+	bolt := documentCarrier{ID: 2, Name: "Bolt", Partners: []documentCarrier{acme, {ID: 3, Name: "Crest"}}}
+
+	got, err := JSONAPIDocument([]documentCarrier{acme, bolt}, "https://example.com", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Acme is already a primary resource, so only Crest is included
+	included, _ := got["included"].([]interface{})
+	if len(included) != 1 || included[0].(map[string]interface{})["id"] != "3" {
+		t.Errorf("got included %v, want only carrier 3", included)
+	}
+}
+
+func TestJSONAPIDocumentProjection(t *testing.T) {
+	projection, err := NewProjection([]string{"name"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	carrier := documentCarrier{ID: 1, Name: "Acme", Drivers: []*documentDriver{{ID: 7, Name: "Pat"}}}
+	got, err := JSONAPIDocument(carrier, "https://example.com", Options{Projection: projection})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := got["data"].(map[string]interface{})
+	if want := map[string]interface{}{"name": "Acme"}; !reflect.DeepEqual(data["attributes"], want) {
+		t.Errorf("got attributes %v, want %v", data["attributes"], want)
+	}
+	if _, ok := data["relationships"]; ok {
+		t.Errorf("got relationships %v, want none", data["relationships"])
+	}
+	if _, ok := got["included"]; ok {
+		t.Errorf("got included %v, want none", got["included"])
+	}
+}
+
+func TestHALDocument(t *testing.T) {
+	carriers := []documentCarrier{{ID: 1, Name: "Acme", Drivers: []*documentDriver{{ID: 7, Name: "Pat"}}}}
+
+	got, err := HALDocument(carriers, "https://example.com", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"_links": map[string]interface{}{"self": halLink("https://example.com/document-carrier")},
+		"_embedded": map[string]interface{}{
+			"document-carrier": []interface{}{
+				map[string]interface{}{
+					"id":   1,
+					"name": "Acme",
+					"_links": map[string]interface{}{
+						"self":     halLink("https://example.com/document-carrier/1"),
+						"drivers":  halLink("https://example.com/document-carrier/1/drivers"),
+						"partners": halLink("https://example.com/document-carrier/1/partners"),
+					},
+					"_embedded": map[string]interface{}{
+						"drivers": []interface{}{
+							map[string]interface{}{
+								"id":     7,
+								"name":   "Pat",
+								"_links": map[string]interface{}{"self": halLink("https://example.com/drivers/7")},
+							},
+						},
+					},
+				},
+			},
+		},
+		"count": 1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDocumentErrors(t *testing.T) {
+	type noID struct {
+		Name string `json:"name"`
+	}
+
+	for _, data := range []interface{}{nil, 1, noID{Name: "a"}} {
+		if _, err := JSONAPIDocument(data, "", Options{}); err == nil {
+			t.Errorf("JSON:API rendered %#v", data)
+		}
+	}
+	if _, err := HALDocument(nil, "", Options{}); err == nil {
+		t.Error("HAL rendered nil")
+	}
+}
@@ -0,0 +1,372 @@
+package api
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Encoder writes the API representation of values as JSON straight to a
+// writer, without building the intermediate maps ToApiData returns. The
+// output is the same as json.Marshal(ToApiData(value)).
+type Encoder struct {
+	w       *bufio.Writer
+	flusher http.Flusher
+	options Options
+	err     error
+}
+
+// NewEncoder returns an Encoder writing to w. If w is an http.ResponseWriter
+// the response is flushed to the client as records are streamed.
+func NewEncoder(w io.Writer) *Encoder {
+	flusher, _ := w.(http.Flusher)
+
+	return &Encoder{
+		w:       bufio.NewWriter(w),
+		flusher: flusher,
+	}
+}
+
+// SetViewer sets the viewer values are rendered for, see ToApiDataFor
+func (enc *Encoder) SetViewer(viewer Viewer) {
+	enc.options.Viewer = viewer
+}
+
+// SetProjection narrows and expands the rendered fields, see
+// ToApiDataProjected
+func (enc *Encoder) SetProjection(projection *Projection) {
+	enc.options.Projection = projection
+}
+
+// SetNaming sets the naming strategy for fields without a json tag name
+func (enc *Encoder) SetNaming(naming NamingStrategy) {
+	enc.options.Naming = naming
+}
+
+// Encode writes the API representation of data followed by a newline
+func (enc *Encoder) Encode(data interface{}) error {
+	if err := enc.validate(data); err != nil {
+		return err
+	}
+
+	enc.newStream().value(reflect.ValueOf(data), enc.fields())
+	enc.w.WriteByte('\n')
+
+	return enc.flush()
+}
+
+// EncodeChannel writes every record received from records as a JSON array,
+// until the channel is closed
+func (enc *Encoder) EncodeChannel(records <-chan interface{}) error {
+	return enc.EncodeIterator(func() (interface{}, bool, error) {
+		// Let the client see what's been written while waiting on the producer
+		if len(records) == 0 {
+			if err := enc.flush(); err != nil {
+				return nil, false, err
+			}
+		}
+
+		record, ok := <-records
+		return record, ok, nil
+	})
+}
+
+// EncodeIterator writes records as a JSON array. next is called until it
+// reports there are no more records, or returns an error.
+func (enc *Encoder) EncodeIterator(next func() (interface{}, bool, error)) error {
+	enc.w.WriteByte('[')
+
+	for first := true; ; first = false {
+		record, ok, err := next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if err := enc.validate(record); err != nil {
+			return err
+		}
+
+		if !first {
+			enc.w.WriteByte(',')
+		}
+		enc.newStream().value(reflect.ValueOf(record), enc.fields())
+
+		if enc.err != nil {
+			return enc.err
+		}
+	}
+
+	enc.w.WriteString("]\n")
+
+	return enc.flush()
+}
+
+func (enc *Encoder) validate(data interface{}) error {
+	if err := CheckTags(data); err != nil {
+		return err
+	}
+	return newEncodeState(enc.options).validateProjection(data, enc.options.Projection)
+}
+
+func (enc *Encoder) fields() fieldTree {
+	if enc.options.Projection == nil {
+		return nil
+	}
+	return enc.options.Projection.fields
+}
+
+func (enc *Encoder) newStream() *streamState {
+	return &streamState{encodeState: newEncodeState(enc.options), enc: enc}
+}
+
+func (enc *Encoder) flush() error {
+	if enc.err != nil {
+		return enc.err
+	}
+
+	if err := enc.w.Flush(); err != nil {
+		enc.err = err
+		return err
+	}
+
+	if enc.flusher != nil {
+		enc.flusher.Flush()
+	}
+
+	return nil
+}
+
+// streamState writes one value, applying the same rules as encodeState
+type streamState struct {
+	*encodeState
+	enc *Encoder
+}
+
+func (s *streamState) value(v reflect.Value, fields fieldTree) {
+	if !v.IsValid() {
+		s.enc.w.WriteString("null")
+		return
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			s.enc.w.WriteString("null")
+			return
+		}
+		s.guard(v, func() {
+			if marshaled, ok := s.marshaled(v); ok {
+				s.generic(project(marshaled, fields))
+			} else {
+				s.value(v.Elem(), fields)
+			}
+		})
+		return
+	}
+
+	if marshaled, ok := s.marshaled(v); ok {
+		s.generic(project(marshaled, fields))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			s.enc.w.WriteString("null")
+			return
+		}
+		s.value(v.Elem(), fields)
+	case reflect.Struct:
+		s.structValue(v, fields)
+	case reflect.Map:
+		if v.IsNil() {
+			s.enc.w.WriteString("null")
+			return
+		}
+		s.guard(v, func() {
+			s.mapValue(v, fields)
+		})
+	case reflect.Slice:
+		if v.IsNil() {
+			s.enc.w.WriteString("null")
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			s.generic(base64.StdEncoding.EncodeToString(v.Bytes()))
+			return
+		}
+		s.guard(v, func() {
+			s.arrayValue(v, fields)
+		})
+	case reflect.Array:
+		s.arrayValue(v, fields)
+	case reflect.Chan, reflect.Func, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer:
+		s.enc.w.WriteString("null")
+	default:
+		s.scalar(v)
+	}
+}
+
+// guard is encodeState.guard for writers, writing null instead of the value
+// when it would start a cycle
+func (s *streamState) guard(v reflect.Value, write func()) {
+	key := seenKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.length = v.Len()
+	}
+
+	if _, ok := s.seen[key]; ok {
+		s.enc.w.WriteString("null")
+		return
+	}
+	s.seen[key] = struct{}{}
+	defer delete(s.seen, key)
+
+	write()
+}
+
+func (s *streamState) structValue(v reflect.Value, fields fieldTree) {
+	s.enc.w.WriteByte('{')
+
+	plan := planFor(v.Type())
+	// Types behind interfaces aren't known until they're reached
+	if plan.err != nil && s.enc.err == nil {
+		s.enc.err = plan.err
+	}
+
+	namedFields := plan.namedFields
+	if s.naming != nil {
+		// Keep the order encoding/json would write the renamed keys in
+		namedFields = append([]field(nil), namedFields...)
+		sort.Slice(namedFields, func(i, j int) bool {
+			return s.fieldName(namedFields[i]) < s.fieldName(namedFields[j])
+		})
+	}
+
+	first := true
+	for _, f := range namedFields {
+		name := s.fieldName(f)
+
+		subfields, selected := fields[name]
+		if len(fields) > 0 && !selected {
+			continue
+		}
+
+		fv, expand, ok := s.fieldValue(v, f)
+		if !ok {
+			continue
+		}
+
+		if !first {
+			s.enc.w.WriteByte(',')
+		}
+		first = false
+
+		s.key(name)
+
+		if f.quoted {
+			s.generic(s.redacted(f.redaction, quotedValue(fv)))
+			continue
+		}
+
+		parentExpand := s.expand
+		s.expand = expand
+		if f.redaction != nil {
+			// Redactions work on rendered values
+			s.generic(project(s.redacted(f.redaction, s.encodeState.value(fv)), subfields))
+		} else {
+			s.value(fv, subfields)
+		}
+		s.expand = parentExpand
+	}
+
+	s.enc.w.WriteByte('}')
+}
+
+func (s *streamState) mapValue(v reflect.Value, fields fieldTree) {
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, ok := mapKey(iter.Key())
+		if !ok {
+			continue
+		}
+		if _, selected := fields[key]; len(fields) > 0 && !selected {
+			continue
+		}
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	s.enc.w.WriteByte('{')
+	for i, entry := range entries {
+		if i > 0 {
+			s.enc.w.WriteByte(',')
+		}
+
+		s.key(entry.key)
+
+		parentExpand := s.expand
+		s.expand = s.expand[entry.key]
+		s.value(entry.value, fields[entry.key])
+		s.expand = parentExpand
+	}
+	s.enc.w.WriteByte('}')
+}
+
+func (s *streamState) arrayValue(v reflect.Value, fields fieldTree) {
+	s.enc.w.WriteByte('[')
+	for index := 0; index < v.Len(); index++ {
+		if index > 0 {
+			s.enc.w.WriteByte(',')
+		}
+		s.value(v.Index(index), fields)
+	}
+	s.enc.w.WriteByte(']')
+}
+
+func (s *streamState) key(name string) {
+	s.generic(name)
+	s.enc.w.WriteByte(':')
+}
+
+func (s *streamState) scalar(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Bool:
+		s.enc.w.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s.enc.w.WriteString(strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s.enc.w.WriteString(strconv.FormatUint(v.Uint(), 10))
+	default:
+		s.generic(scalarValue(v))
+	}
+}
+
+// generic writes an already rendered value
+func (s *streamState) generic(value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		if s.enc.err == nil {
+			s.enc.err = err
+		}
+		return
+	}
+
+	s.enc.w.Write(encoded)
+}
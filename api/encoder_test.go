@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeToString returns what Encoder writes for data
+func encodeToString(t *testing.T, enc func(*Encoder), data interface{}) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	encoder := NewEncoder(&buf)
+	if enc != nil {
+		enc(encoder)
+	}
+	if err := encoder.Encode(data); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// marshalApiData returns json.Marshal(ToApiData(data)) followed by a newline
+func marshalApiData(t *testing.T, options Options, data interface{}) string {
+	t.Helper()
+
+	rendered, err := ToApiDataWithOptions(data, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded) + "\n"
+}
+
+func TestEncoderMatchesToApiData(t *testing.T) {
+	node := &cyclicNode{Name: "a"}
+	node.Next = node
+
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"scalar", 12},
+		{"string escaping", "<a & \"b\">\n"},
+		{"nil", nil},
+		{"struct", newProjectionCarrier()},
+		{"slice of structs", benchCarriers()[:3]},
+		{"map", map[string]interface{}{"b": 1, "a": []int{1, 2}}},
+		{"bytes", []byte("hi")},
+		{"json tags", struct {
+			A int    `json:"a,string"`
+			B string `json:"b,omitempty"`
+			C string `json:"-"`
+		}{A: 1}},
+		{"marshalers", []interface{}{marshalAPI{Secret: "abc"}, marshalJSON{}, &marshalText{Code: "a"}, marshalID{'a', 'b'}}},
+		{"time", time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{"cycle", node},
+		{"embedded", embeddedCarrier{embeddedBase: embeddedBase{ID: 1}, Code: "AC"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := marshalApiData(t, Options{}, test.value)
+			if got := encodeToString(t, nil, test.value); got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	projection, err := NewProjection([]string{"id", "notes", "owner"}, []string{"owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := Options{Viewer: testViewer{"admin"}, Projection: projection, Naming: SnakeCase}
+
+	got := encodeToString(t, func(enc *Encoder) {
+		enc.SetViewer(options.Viewer)
+		enc.SetProjection(options.Projection)
+		enc.SetNaming(options.Naming)
+	}, newProjectionCarrier())
+
+	if want := marshalApiData(t, options, newProjectionCarrier()); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestEncoderProjectionErrors(t *testing.T) {
+	projection, err := NewProjection([]string{"missing"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	encoder := NewEncoder(&buf)
+	encoder.SetProjection(projection)
+
+	var unknown *UnknownFieldsError
+	if err := encoder.Encode(newProjectionCarrier()); !errors.As(err, &unknown) {
+		t.Errorf("got %v, want an UnknownFieldsError", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q before failing", buf.String())
+	}
+}
+
+func TestEncoderEncodeChannel(t *testing.T) {
+	records := make(chan interface{}, 3)
+	for _, carrier := range benchCarriers()[:3] {
+		records <- carrier
+	}
+	close(records)
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).EncodeChannel(records); err != nil {
+		t.Fatal(err)
+	}
+
+	var carriers []interface{}
+	for _, carrier := range benchCarriers()[:3] {
+		carriers = append(carriers, carrier)
+	}
+	if want := marshalApiData(t, Options{}, carriers); buf.String() != want {
+		t.Errorf("got %s, want %s", buf.String(), want)
+	}
+
+	// An empty stream is an empty array
+	empty := make(chan interface{})
+	close(empty)
+	buf.Reset()
+	if err := NewEncoder(&buf).EncodeChannel(empty); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "[]\n" {
+		t.Errorf("got %q for an empty stream, want []", buf.String())
+	}
+}
+
+func TestEncoderEncodeIteratorError(t *testing.T) {
+	failure := errors.New("failed")
+
+	var buf bytes.Buffer
+	calls := 0
+	err := NewEncoder(&buf).EncodeIterator(func() (interface{}, bool, error) {
+		calls++
+		if calls > 2 {
+			return nil, false, failure
+		}
+		return calls, true, nil
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("got %v, want %v", err, failure)
+	}
+	if strings.HasSuffix(buf.String(), "]\n") {
+		t.Errorf("closed the array after an error: %q", buf.String())
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// field describes how a struct field is rendered
+type field struct {
+	name string
+	// Path of field indexes from the outer struct, through embedded structs
+	index     []int
+	typ       reflect.Type
+	tagged    bool
+	omitEmpty bool
+	quoted    bool
+	// Each set must be satisfied by the viewer, one per level of embedding
+	roles [][]string
+	// Only rendered when requested through a Projection
+	expandable bool
+	// Can't be written by FromApiData
+	readOnly bool
+	// Directives of the validate tag
+	rules apiTag
+	// How the value is hidden from viewers who can't see it in full
+	redaction *redaction
+	// Identifies the resource in JSON:API and HAL documents
+	resourceID bool
+	// The resource type, set on the ID field
+	resourceType string
+	// Rendered as a link to related resources in JSON:API and HAL documents
+	relationship bool
+}
+
+// typeFields returns the fields of struct type t that should be rendered,
+// with embedded structs flattened according to the same visibility and
+// dominance rules as encoding/json. Problems with api tags are returned with
+// the fields, and a field whose redaction is mistyped is redacted entirely.
+func typeFields(t reflect.Type) ([]field, error) {
+	type queued struct {
+		typ   reflect.Type
+		index []int
+		roles [][]string
+	}
+
+	current := []queued{}
+	next := []queued{{typ: t}}
+
+	// Number of times each type name was seen at the current and next depth
+	count := map[reflect.Type]int{}
+	nextCount := map[reflect.Type]int{}
+
+	visited := map[reflect.Type]bool{}
+
+	var fields []field
+	var tagErr error
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					// Embedded unexported non-structs have no promotable fields
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+
+				apiTag := parseApiTag(sf.Tag.Get("api"))
+				if apiTag.has("exclude") {
+					continue
+				}
+
+				// A mistyped mask must not leak the value it was meant to hide,
+				// so the field is redacted and the problem kept with the plan
+				redaction, err := parseRedaction(apiTag)
+				if err != nil && tagErr == nil {
+					tagErr = fmt.Errorf("api: field %s of %s: %v", sf.Name, q.typ, err)
+				}
+
+				roles := q.roles
+				if apiTag.has("roles") {
+					roles = append(append([][]string(nil), q.roles...), apiTag.list("roles"))
+				}
+
+				jsonTag := sf.Tag.Get("json")
+				if jsonTag == "-" {
+					continue
+				}
+
+				name, opts := parseTag(jsonTag)
+				if !isValidTag(name) {
+					name = ""
+				}
+
+				index := make([]int, len(q.index)+1)
+				copy(index, q.index)
+				index[len(q.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				// Named fields and untagged embedded non-structs are rendered as-is
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+
+					fields = append(fields, field{
+						name:       name,
+						index:      index,
+						typ:        sf.Type,
+						tagged:     tagged,
+						omitEmpty:  opts.Contains("omitempty"),
+						quoted:     opts.Contains("string") && isQuotableKind(sf.Type),
+						roles:      roles,
+						expandable: apiTag.has("expandable"),
+						readOnly:   apiTag.has("readonly"),
+						rules:      parseValidateTag(sf.Tag.Get("validate")),
+						redaction:  redaction,
+
+						resourceID:   apiTag.has("id"),
+						resourceType: apiTag["type"],
+						relationship: apiTag.has("relationship"),
+					})
+
+					// An embedded type seen more than once at this depth
+					// conflicts with itself, so add a duplicate to cancel it out
+					if count[q.typ] > 1 {
+						fields = append(fields, fields[len(fields)-1])
+					}
+
+					continue
+				}
+
+				// Flatten the embedded struct at the next depth
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, queued{typ: ft, index: index, roles: roles})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		if fields[i].tagged != fields[j].tagged {
+			return fields[i].tagged
+		}
+		return indexLess(fields[i].index, fields[j].index)
+	})
+
+	// Keep only the dominant field for each name, dropping ambiguous ones
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+
+		if dominant, ok := dominantField(fields[i : i+advance]); ok {
+			out = append(out, dominant)
+		}
+	}
+	fields = out
+
+	// Render fields in declaration order
+	sort.Slice(fields, func(i, j int) bool {
+		return indexLess(fields[i].index, fields[j].index)
+	})
+
+	return fields, tagErr
+}
+
+// dominantField picks the field that wins among fields sharing a name, which
+// are sorted by depth and then by whether they're tagged. There's no winner if
+// several fields tie.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) > 1 && len(fields[0].index) == len(fields[1].index) && fields[0].tagged == fields[1].tagged {
+		return field{}, false
+	}
+	return fields[0], true
+}
+
+func indexLess(a, b []int) bool {
+	for k, x := range a {
+		if k >= len(b) {
+			return false
+		}
+		if x != b[k] {
+			return x < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
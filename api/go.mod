@@ -0,0 +1,3 @@
+module github.com/williamhaley/go/api
+
+go 1.17
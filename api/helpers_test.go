@@ -0,0 +1,13 @@
+package api
+
+import "sort"
+
+// sortedKeys returns the keys of a rendered object in order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
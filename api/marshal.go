@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ApiMarshaler is implemented by types that control their own API
+// representation. The returned value is converted with the same rules as
+// ToApiData.
+type ApiMarshaler interface {
+	ToApiData() interface{}
+}
+
+// Fields is a map that an ApiMarshaler can return to hand its fields over to
+// the api package, which then renders the values in place rather than in a
+// copy of the map. Keys that are the Go names of the struct's fields without
+// a json tag name are renamed by the naming strategy, as they would be if the
+// struct were rendered by reflection. The methods generated by apigen return
+// Fields.
+type Fields map[string]interface{}
+
+var (
+	apiMarshalerType  = reflect.TypeOf((*ApiMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+)
+
+// marshaled renders v through the first interface it implements, in order of
+// preference: ApiMarshaler, json.Marshaler, encoding.TextMarshaler and, for
+// values that would otherwise render as a bare struct or array, fmt.Stringer.
+// Pointer receivers are used when v is addressable, like encoding/json.
+func (e *encodeState) marshaled(v reflect.Value) (interface{}, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, false
+	}
+
+	plan := planFor(v.Type())
+
+	kind := plan.marshaler
+	if kind == noMarshaler && plan.addrMarshaler != noMarshaler && v.CanAddr() {
+		kind = plan.addrMarshaler
+		v = v.Addr()
+	}
+
+	switch kind {
+	case timeMarshaler:
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), true
+	case apiMarshaler:
+		data := v.Interface().(ApiMarshaler).ToApiData()
+		if fields, ok := data.(Fields); ok {
+			e.renameFields(fields, v.Type())
+		}
+		return e.interfaceValue(data), true
+	case jsonMarshaler:
+		return jsonMarshalerValue(v.Interface().(json.Marshaler)), true
+	case textMarshaler:
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, true
+		}
+		return string(text), true
+	case stringMarshaler:
+		return v.Interface().(fmt.Stringer).String(), true
+	}
+
+	return nil, false
+}
+
+// renameFields applies the naming strategy to the fields that struct type t
+// handed over, in place
+func (e *encodeState) renameFields(fields Fields, t reflect.Type) {
+	if e.naming == nil {
+		return
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+
+	// Take every renamed value out first so a new name can't overwrite a
+	// field that hasn't been renamed yet
+	renamed := map[string]interface{}{}
+	for _, f := range cachedTypeFields(t) {
+		if f.tagged {
+			continue
+		}
+		if value, ok := fields[f.name]; ok {
+			delete(fields, f.name)
+			renamed[e.naming(f.name)] = value
+		}
+	}
+
+	for name, value := range renamed {
+		fields[name] = value
+	}
+}
+
+func asInterface(v reflect.Value, iface reflect.Type) (interface{}, bool) {
+	if v.Type().Implements(iface) {
+		return v.Interface(), true
+	}
+
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(iface) {
+		return v.Addr().Interface(), true
+	}
+
+	return nil, false
+}
+
+// jsonMarshalerValue decodes the marshaled JSON back into generic values.
+// Numbers are kept as json.Number so they aren't rounded.
+func jsonMarshalerValue(m json.Marshaler) interface{} {
+	encoded, err := m.MarshalJSON()
+	if err != nil {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil
+	}
+
+	return decoded
+}
+
+// isOpaque reports whether t would render without any useful structure, such
+// as arrays of bytes backing IDs or structs without exported fields
+func isOpaque(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Array:
+		return true
+	case reflect.Struct:
+		fields, _ := typeFields(t)
+		return len(fields) == 0
+	}
+	return false
+}
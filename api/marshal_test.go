@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type marshalAPI struct {
+	Secret string
+}
+
+func (m marshalAPI) ToApiData() interface{} {
+	return map[string]interface{}{"masked": len(m.Secret)}
+}
+
+type marshalJSON struct{}
+
+func (marshalJSON) MarshalJSON() ([]byte, error) {
+	return []byte(`{"big":12345678901234567890,"list":[1,"a"]}`), nil
+}
+
+type marshalJSONError struct{}
+
+func (marshalJSONError) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("failed")
+}
+
+type marshalText struct {
+	Code string
+}
+
+func (m *marshalText) MarshalText() ([]byte, error) {
+	return []byte("code:" + m.Code), nil
+}
+
+type marshalID [2]byte
+
+func (id marshalID) String() string {
+	return "id-" + string(id[:])
+}
+
+type marshalStringerStruct struct {
+	Name string `json:"name"`
+}
+
+func (marshalStringerStruct) String() string {
+	return "ignored"
+}
+
+func TestToApiDataMarshalers(t *testing.T) {
+	moment := time.Date(2026, 3, 1, 12, 30, 0, 5, time.UTC)
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"api marshaler", marshalAPI{Secret: "abc"}, map[string]interface{}{"masked": 3}},
+		{
+			"json marshaler keeps numbers exact",
+			marshalJSON{},
+			map[string]interface{}{"big": json.Number("12345678901234567890"), "list": []interface{}{json.Number("1"), "a"}},
+		},
+		{"json marshaler error", marshalJSONError{}, nil},
+		{"text marshaler through a pointer", &marshalText{Code: "a"}, "code:a"},
+		{"text marshaler of an addressable field", &struct{ M marshalText }{marshalText{Code: "b"}}, map[string]interface{}{"M": "code:b"}},
+		{"text marshaler of a value isn't used", marshalText{Code: "c"}, map[string]interface{}{"Code": "c"}},
+		{"stringer of an opaque type", marshalID{'a', 'b'}, "id-ab"},
+		{"stringer of a struct with fields isn't used", marshalStringerStruct{Name: "a"}, map[string]interface{}{"name": "a"}},
+		{"time", moment, "2026-03-01T12:30:00.000000005Z"},
+		{"time pointer", &moment, "2026-03-01T12:30:00.000000005Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rendered := ToApiData(test.value); !reflect.DeepEqual(rendered, test.want) {
+				t.Errorf("got %#v, want %#v", rendered, test.want)
+			}
+		})
+	}
+}
+
+type marshalSelf struct {
+	Name string
+}
+
+func (m *marshalSelf) ToApiData() interface{} {
+	return m
+}
+
+func TestToApiDataMarshalerReturningItself(t *testing.T) {
+	if rendered := ToApiData(&marshalSelf{Name: "a"}); rendered != nil {
+		t.Errorf("got %#v, want nil", rendered)
+	}
+}
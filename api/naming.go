@@ -0,0 +1,124 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// NamingStrategy derives the rendered name of a field from its Go name. It's
+// only applied to fields without a name in their json tag.
+type NamingStrategy func(fieldName string) string
+
+var (
+	// SnakeCase renders CarrierID as carrier_id
+	SnakeCase = cachedNaming(func(name string) string {
+		return strings.Join(lowerWords(name), "_")
+	})
+	// KebabCase renders CarrierID as carrier-id
+	KebabCase = cachedNaming(func(name string) string {
+		return strings.Join(lowerWords(name), "-")
+	})
+	// CamelCase renders CarrierID as carrierId
+	CamelCase = cachedNaming(func(name string) string {
+		words := lowerWords(name)
+		for i := 1; i < len(words); i++ {
+			runes := []rune(words[i])
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+		return strings.Join(words, "")
+	})
+)
+
+// Options configures ToApiDataWithOptions, FromApiDataWithOptions and the
+// document renderers
+type Options struct {
+	Viewer     Viewer
+	Projection *Projection
+	// Field names are used as-is if nil
+	Naming NamingStrategy
+}
+
+// ToApiDataWithOptions is ToApiDataProjected with a naming strategy
+func ToApiDataWithOptions(data interface{}, options Options) (interface{}, error) {
+	e := newEncodeState(options)
+
+	if err := e.validateProjection(data, options.Projection); err != nil {
+		return nil, err
+	}
+
+	rendered := e.value(reflect.ValueOf(data))
+	if options.Projection != nil {
+		rendered = project(rendered, options.Projection.fields)
+	}
+
+	return rendered, nil
+}
+
+func newEncodeState(options Options) *encodeState {
+	e := &encodeState{
+		seen:   make(map[seenKey]struct{}),
+		viewer: normalizeViewer(options.Viewer),
+		naming: options.Naming,
+	}
+	if options.Projection != nil {
+		e.expand = options.Projection.expand
+	}
+
+	return e
+}
+
+// fieldName is the rendered name of f
+func (e *encodeState) fieldName(f field) string {
+	if f.tagged || e.naming == nil {
+		return f.name
+	}
+	return e.naming(f.name)
+}
+
+func cachedNaming(convert func(string) string) NamingStrategy {
+	var cache sync.Map // map[string]string
+
+	return func(name string) string {
+		if converted, ok := cache.Load(name); ok {
+			return converted.(string)
+		}
+
+		converted := convert(name)
+		cache.Store(name, converted)
+
+		return converted
+	}
+}
+
+// lowerWords splits a Go identifier into lower case words, keeping
+// initialisms together: CarrierID becomes carrier and id, and HTTPServer
+// becomes http and server
+func lowerWords(name string) []string {
+	runes := []rune(name)
+
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		previous, current := runes[i-1], runes[i]
+
+		isBoundary := (unicode.IsLower(previous) || unicode.IsDigit(previous)) && unicode.IsUpper(current)
+		// The last capital of an initialism starts the next word
+		isBoundary = isBoundary || (unicode.IsUpper(previous) && unicode.IsUpper(current) && i+1 < len(runes) && unicode.IsLower(runes[i+1]))
+		isBoundary = isBoundary || current == '_'
+
+		if isBoundary {
+			if word := strings.Trim(string(runes[start:i]), "_"); word != "" {
+				words = append(words, strings.ToLower(word))
+			}
+			start = i
+		}
+	}
+	if word := strings.Trim(string(runes[start:]), "_"); word != "" {
+		words = append(words, strings.ToLower(word))
+	}
+
+	return words
+}
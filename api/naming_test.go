@@ -0,0 +1,76 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+type namingCarrier struct {
+	CarrierID  int
+	HTTPServer string
+	Name       string `json:"NAME"`
+	DOTNumber  string `json:",omitempty"`
+}
+
+func TestNamingStrategies(t *testing.T) {
+	tests := []struct {
+		name   string
+		naming NamingStrategy
+		want   []string
+	}{
+		{"snake", SnakeCase, []string{"carrier_id", "http_server", "dot_number"}},
+		{"kebab", KebabCase, []string{"carrier-id", "http-server", "dot-number"}},
+		{"camel", CamelCase, []string{"carrierId", "httpServer", "dotNumber"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, name := range []string{"CarrierID", "HTTPServer", "DOTNumber"} {
+				got = append(got, test.naming(name))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestToApiDataWithOptionsNaming(t *testing.T) {
+	got, err := ToApiDataWithOptions(namingCarrier{CarrierID: 1, HTTPServer: "a", Name: "Acme"}, Options{Naming: SnakeCase})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Names from json tags are kept
+	want := map[string]interface{}{"carrier_id": 1, "http_server": "a", "NAME": "Acme"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFromApiDataWithOptionsNaming(t *testing.T) {
+	options := Options{Naming: SnakeCase}
+
+	var carrier namingCarrier
+	err := FromApiDataWithOptions([]byte(`{"carrier_id": 1, "dot_number": "123", "NAME": "Acme"}`), &carrier, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (namingCarrier{CarrierID: 1, DOTNumber: "123", Name: "Acme"}); carrier != want {
+		t.Errorf("got %+v, want %+v", carrier, want)
+	}
+
+	if err := PatchFromApiDataWithOptions([]byte(`{"http_server": "b"}`), &carrier, options); err != nil {
+		t.Fatal(err)
+	}
+	if carrier.HTTPServer != "b" || carrier.CarrierID != 1 {
+		t.Errorf("got %+v after the patch", carrier)
+	}
+
+	// Go names aren't accepted once a strategy renames them
+	fields := decodeErrorFields(t, FromApiDataWithOptions([]byte(`{"CarrierID": 2}`), &carrier, options))
+	if _, ok := fields["CarrierID"]; !ok || len(fields) != 1 {
+		t.Errorf("got errors %v, want one for CarrierID", fields)
+	}
+}
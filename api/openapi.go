@@ -0,0 +1,486 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Document is an OpenAPI 3 document. Component schemas are generated from Go
+// types following the same rules as ToApiData, and handlers register their
+// operations so the whole API can be served as openapi.json.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	// Naming strategy for fields without a json tag name, as in Options
+	Naming NamingStrategy `json:"-"`
+
+	mutex sync.Mutex
+	// Component names already given to types
+	names map[reflect.Type]string
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// NewDocument returns an empty Document
+func NewDocument(title, version string) *Document {
+	return &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:   title,
+			Version: version,
+		},
+		Paths: map[string]map[string]*Operation{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+		},
+		names: map[reflect.Type]string{},
+	}
+}
+
+// AddOperation registers an operation for method on path, e.g.
+// AddOperation("get", "/carriers/{id}", ...)
+func (d *Document) AddOperation(method, path string, operation *Operation) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.Paths[path] == nil {
+		d.Paths[path] = map[string]*Operation{}
+	}
+	d.Paths[path][strings.ToLower(method)] = operation
+}
+
+// SchemaFor returns the schema of the API representation of example's type.
+// Struct types are added to the document's components and referenced.
+func (d *Document) SchemaFor(example interface{}) *Schema {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.schema(reflect.TypeOf(example))
+}
+
+// JSONResponse is a Response with a JSON body of schema
+func JSONResponse(description string, schema *Schema) *Response {
+	return &Response{
+		Description: description,
+		Content: map[string]MediaType{
+			"application/json": {Schema: schema},
+		},
+	}
+}
+
+// JSONRequestBody is a RequestBody of JSON matching schema
+func JSONRequestBody(schema *Schema, required bool) *RequestBody {
+	return &RequestBody{
+		Required: required,
+		Content: map[string]MediaType{
+			"application/json": {Schema: schema},
+		},
+	}
+}
+
+// Handler serves the document as JSON
+func (d *Document) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d.mutex.Lock()
+		encoded, err := json.MarshalIndent(d, "", "  ")
+		d.mutex.Unlock()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(encoded)
+	}
+}
+
+func (d *Document) schema(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return nullable(d.schema(t.Elem()))
+	}
+
+	switch planFor(t).marshaler {
+	case timeMarshaler:
+		return &Schema{Type: "string", Format: "date-time"}
+	case textMarshaler, stringMarshaler:
+		return &Schema{Type: "string"}
+	case apiMarshaler:
+		// Structs that hand over their fields, like those apigen generates
+		// methods for, are described like any other struct
+		if t.Kind() != reflect.Struct || !handsOverFields(t) {
+			return &Schema{}
+		}
+	case jsonMarshaler:
+		// The representation isn't known up front
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Interface:
+		return &Schema{Nullable: true}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: true}
+		}
+		return &Schema{Type: "array", Items: d.schema(t.Elem()), Nullable: true}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: d.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schema(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + d.component(t)}
+	}
+
+	return &Schema{}
+}
+
+// handsOverFields reports whether the ApiMarshaler of struct type t returns
+// Fields, judging by its zero value
+func handsOverFields(t reflect.Type) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	_, ok = reflect.Zero(t).Interface().(ApiMarshaler).ToApiData().(Fields)
+	return ok
+}
+
+// component adds struct type t to the components if needed and returns its
+// name
+func (d *Document) component(t reflect.Type) string {
+	if name, ok := d.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, taken := d.Components.Schemas[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+	}
+
+	// Claim the name first so recursive types refer back to it
+	d.names[t] = name
+	d.Components.Schemas[name] = &Schema{}
+	*d.Components.Schemas[name] = *d.structSchema(t)
+
+	return name
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{
+		Type:       "object",
+		Properties: map[string]*Schema{},
+	}
+
+	for _, f := range cachedTypeFields(t) {
+		name := f.name
+		if !f.tagged && d.Naming != nil {
+			name = d.Naming(f.name)
+		}
+
+		var property *Schema
+		if f.quoted {
+			property = &Schema{Type: "string", Nullable: f.typ.Kind() == reflect.Ptr}
+		} else {
+			property = d.schema(f.typ)
+		}
+
+		property = withRules(property, f)
+
+		var notes []string
+		if len(f.roles) > 0 {
+			var roles []string
+			for _, set := range f.roles {
+				roles = append(roles, strings.Join(set, " or "))
+			}
+			notes = append(notes, fmt.Sprintf("Only visible to %s.", strings.Join(roles, " and ")))
+		}
+		if f.redaction != nil {
+			property = redactedSchema(property, f)
+			notes = append(notes, redactionNote(f.redaction))
+		}
+		if f.expandable {
+			notes = append(notes, fmt.Sprintf("Only included when expanded with expand=%s.", name))
+		}
+		if len(notes) > 0 {
+			property = describe(property, strings.Join(notes, " "))
+		}
+
+		// Nobody can write a field that's redacted for every viewer
+		if f.readOnly || (f.redaction != nil && len(f.redaction.unmask) == 0) {
+			property = readOnly(property)
+		}
+
+		schema.Properties[name] = property
+
+		if !f.omitEmpty && len(f.roles) == 0 && !f.expandable {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	sort.Strings(schema.Required)
+
+	return schema
+}
+
+// redactedSchema is the schema of a redacted field. Masks and digests are
+// strings, and redacted values are null, for viewers who can't see the
+// original value.
+func redactedSchema(property *Schema, f field) *Schema {
+	if f.redaction.mode == "redact" {
+		return nullable(property)
+	}
+
+	masked := maskedSchema(f.typ)
+	if len(f.redaction.unmask) == 0 {
+		return masked
+	}
+	return &Schema{AnyOf: []*Schema{property, masked}}
+}
+
+// maskedSchema follows redaction.transform, which keeps the shape of lists
+// and objects but turns every value in them into a string
+func maskedSchema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		return nullable(maskedSchema(t.Elem()))
+	}
+
+	if planFor(t).marshaler == noMarshaler {
+		switch t.Kind() {
+		case reflect.Slice:
+			if t.Elem().Kind() != reflect.Uint8 {
+				return &Schema{Type: "array", Items: maskedSchema(t.Elem()), Nullable: true}
+			}
+		case reflect.Array:
+			return &Schema{Type: "array", Items: maskedSchema(t.Elem())}
+		case reflect.Map:
+			return &Schema{Type: "object", AdditionalProperties: maskedSchema(t.Elem()), Nullable: true}
+		case reflect.Struct:
+			return &Schema{Type: "object", AdditionalProperties: &Schema{}}
+		case reflect.Interface:
+			return &Schema{Nullable: true}
+		}
+	}
+
+	return &Schema{Type: "string"}
+}
+
+func redactionNote(r *redaction) string {
+	var hidden string
+	switch r.mode {
+	case "redact":
+		hidden = "Null"
+	case "hash":
+		hidden = "Replaced with a digest"
+	default:
+		hidden = fmt.Sprintf("Masked (%s)", r.mask)
+	}
+
+	if len(r.unmask) == 0 {
+		return hidden + " for every viewer."
+	}
+	return fmt.Sprintf("%s and read-only unless visible to %s.", hidden, strings.Join(r.unmask, " or "))
+}
+
+// withRules adds the constraints of a field's validate tag
+func withRules(schema *Schema, f field) *Schema {
+	if len(f.rules) == 0 || schema.Ref != "" || len(schema.AllOf) > 0 {
+		return schema
+	}
+
+	constrained := *schema
+
+	kind := indirectType(f.typ).Kind()
+
+	applyBound := func(value string, isMinimum bool) {
+		bound, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return
+		}
+		n := int(bound)
+
+		switch constrained.Type {
+		case "array":
+			if isMinimum {
+				constrained.MinItems = &n
+			} else {
+				constrained.MaxItems = &n
+			}
+		case "string":
+			if kind != reflect.String {
+				return
+			}
+			if isMinimum {
+				constrained.MinLength = &n
+			} else {
+				constrained.MaxLength = &n
+			}
+		case "integer", "number":
+			if isMinimum {
+				constrained.Minimum = &bound
+			} else {
+				constrained.Maximum = &bound
+			}
+		}
+	}
+
+	if value, ok := f.rules["min"]; ok {
+		applyBound(value, true)
+	}
+	if value, ok := f.rules["max"]; ok {
+		applyBound(value, false)
+	}
+
+	if pattern, ok := f.rules["regex"]; ok && kind == reflect.String {
+		constrained.Pattern = pattern
+	}
+
+	if f.rules.has("enum") {
+		for _, value := range f.rules.list("enum") {
+			constrained.Enum = append(constrained.Enum, enumValue(constrained.Type, value))
+		}
+	}
+
+	return &constrained
+}
+
+func enumValue(schemaType, value string) interface{} {
+	switch schemaType {
+	case "integer":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// nullable, describe and readOnly wrap references in allOf, since OpenAPI 3.0
+// ignores siblings of $ref
+func nullable(schema *Schema) *Schema {
+	if schema.Ref != "" {
+		return &Schema{AllOf: []*Schema{schema}, Nullable: true}
+	}
+
+	copied := *schema
+	copied.Nullable = true
+	return &copied
+}
+
+func describe(schema *Schema, description string) *Schema {
+	if schema.Ref != "" {
+		return &Schema{AllOf: []*Schema{schema}, Description: description}
+	}
+
+	copied := *schema
+	copied.Description = description
+	return &copied
+}
+
+func readOnly(schema *Schema) *Schema {
+	if schema.Ref != "" {
+		return &Schema{AllOf: []*Schema{schema}, ReadOnly: true}
+	}
+
+	copied := *schema
+	copied.ReadOnly = true
+	return &copied
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type openAPIOwner struct {
+	Name string `json:"name"`
+}
+
+type openAPICarrier struct {
+	ID       int             `json:"id" api:"readonly"`
+	Name     string          `json:"name" validate:"min=2;max=40;regex=^[A-Z]"`
+	Fleet    int32           `json:"fleet,omitempty" validate:"min=1;max=100"`
+	Rating   float64         `json:"rating,string"`
+	State    string          `json:"state" validate:"enum=IL,WI"`
+	Tags     []string        `json:"tags" validate:"max=3"`
+	Owner    *openAPIOwner   `json:"owner"`
+	Parent   *openAPICarrier `json:"parent" api:"expandable"`
+	Notes    string          `json:"notes" api:"roles=admin,owner"`
+	Created  time.Time       `json:"created"`
+	Labels   map[string]int  `json:"labels"`
+	Raw      []byte          `json:"raw"`
+	Anything interface{}     `json:"anything"`
+	Untagged bool
+}
+
+func TestSchemaFor(t *testing.T) {
+	document := NewDocument("Carriers", "1.0")
+	document.Naming = SnakeCase
+
+	ref := document.SchemaFor(openAPICarrier{})
+	if ref.Ref != "#/components/schemas/openAPICarrier" {
+		t.Fatalf("got %+v, want a reference", ref)
+	}
+
+	schema := document.Components.Schemas["openAPICarrier"]
+	if schema == nil || schema.Type != "object" {
+		t.Fatalf("got component %+v", schema)
+	}
+
+	wantRequired := []string{"anything", "created", "id", "labels", "name", "owner", "rating", "raw", "state", "tags", "untagged"}
+	if !reflect.DeepEqual(schema.Required, wantRequired) {
+		t.Errorf("got required %v, want %v", schema.Required, wantRequired)
+	}
+
+	one, forty, hundred, three := 1.0, 40, 100.0, 3
+	two := 2
+	tests := []struct {
+		property string
+		want     *Schema
+	}{
+		{"id", &Schema{Type: "integer", Format: "int64", ReadOnly: true}},
+		{"name", &Schema{Type: "string", MinLength: &two, MaxLength: &forty, Pattern: "^[A-Z]"}},
+		{"fleet", &Schema{Type: "integer", Format: "int32", Minimum: &one, Maximum: &hundred}},
+		{"rating", &Schema{Type: "string"}},
+		{"state", &Schema{Type: "string", Enum: []interface{}{"IL", "WI"}}},
+		{"tags", &Schema{Type: "array", Items: &Schema{Type: "string"}, Nullable: true, MaxItems: &three}},
+		{"owner", &Schema{AllOf: []*Schema{{Ref: "#/components/schemas/openAPIOwner"}}, Nullable: true}},
+		{"parent", &Schema{
+			AllOf:       []*Schema{{Ref: "#/components/schemas/openAPICarrier"}},
+			Nullable:    true,
+			Description: "Only included when expanded with expand=parent.",
+		}},
+		{"notes", &Schema{Type: "string", Description: "Only visible to admin or owner."}},
+		{"created", &Schema{Type: "string", Format: "date-time"}},
+		{"labels", &Schema{Type: "object", AdditionalProperties: &Schema{Type: "integer", Format: "int64"}, Nullable: true}},
+		{"raw", &Schema{Type: "string", Format: "byte", Nullable: true}},
+		{"anything", &Schema{Nullable: true}},
+		{"untagged", &Schema{Type: "boolean"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.property, func(t *testing.T) {
+			if got := schema.Properties[test.property]; !reflect.DeepEqual(got, test.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(test.want)
+				t.Errorf("got %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+
+	if len(schema.Properties) != len(tests) {
+		t.Errorf("got %d properties, want %d", len(schema.Properties), len(tests))
+	}
+}
+
+func TestSchemaForNameCollisions(t *testing.T) {
+	document := NewDocument("Carriers", "1.0")
+
+	type openAPIOwner struct {
+		ID int `json:"id"`
+	}
+
+	first := document.SchemaFor(struct{ A openAPIOwner }{})
+	second := document.SchemaFor(struct{ B openAPIOwnerAlias }{})
+	if first.Properties["A"].Ref == second.Properties["B"].Ref {
+		t.Errorf("both types refer to %s", first.Properties["A"].Ref)
+	}
+	if len(document.Components.Schemas) != 2 {
+		t.Errorf("got %d components, want 2", len(document.Components.Schemas))
+	}
+}
+
+// openAPIOwnerAlias is named like the type declared in TestSchemaForNameCollisions
+type openAPIOwnerAlias = openAPIOwner
+
+func TestDocumentHandler(t *testing.T) {
+	document := NewDocument("Carriers", "1.0")
+	document.AddOperation("GET", "/carriers/{id}", &Operation{
+		OperationID: "getCarrier",
+		Responses: map[string]*Response{
+			"200": JSONResponse("A carrier", document.SchemaFor(openAPIOwner{})),
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	document.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got Content-Type %q", recorder.Header().Get("Content-Type"))
+	}
+
+	var served struct {
+		OpenAPI string                                       `json:"openapi"`
+		Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if served.OpenAPI != "3.0.3" || served.Paths["/carriers/{id}"]["get"]["operationId"] != "getCarrier" {
+		t.Errorf("got %s", recorder.Body.String())
+	}
+}
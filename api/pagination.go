@@ -0,0 +1,181 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page is the standard envelope for list responses
+type Page struct {
+	Data interface{} `json:"data"`
+	Next string      `json:"next,omitempty"`
+	Prev string      `json:"prev,omitempty"`
+	// Only set when counting is cheap enough to do on every request
+	Total *int      `json:"total,omitempty"`
+	Links PageLinks `json:"links"`
+}
+
+type PageLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// PageBounds describes where a page sits in the underlying key space, e.g.
+// from data_store.PageInfo
+type PageBounds struct {
+	// The collection and key prefix the page was read from, e.g.
+	// "carriers/acme:". Cursors to the neighbouring pages are only accepted
+	// for the same scope.
+	Scope    string
+	FirstKey string
+	LastKey  string
+	HasNext  bool
+	HasPrev  bool
+}
+
+// Cursor is a position between two store keys
+type Cursor struct {
+	// The scope of the page the cursor was issued for, see PageBounds
+	Scope string `json:"s,omitempty"`
+	// Records after Key are wanted, or before it if Backward is set
+	Key      string `json:"k"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// PageRequest is what a client asked for through query parameters
+type PageRequest struct {
+	// Nil for the first page
+	Cursor *Cursor
+	Limit  int
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorSigner turns cursors into opaque tokens that clients can't forge or
+// tamper with
+type CursorSigner struct {
+	secret []byte
+}
+
+func NewCursorSigner(secret []byte) *CursorSigner {
+	return &CursorSigner{
+		secret: secret,
+	}
+}
+
+func (s *CursorSigner) Encode(cursor Cursor) string {
+	payload, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+func (s *CursorSigner) Decode(token string) (*Cursor, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func (s *CursorSigner) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)[:16]
+}
+
+// ParsePageRequest reads the "cursor" and "limit" query parameters for a list
+// of scope, see PageBounds. Cursors issued for another scope are rejected with
+// ErrInvalidCursor. The limit defaults to defaultLimit and is capped at
+// maxLimit.
+func (s *CursorSigner) ParsePageRequest(query url.Values, scope string, defaultLimit, maxLimit int) (*PageRequest, error) {
+	request := &PageRequest{
+		Limit: defaultLimit,
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", limit)
+		}
+		request.Limit = parsed
+	}
+	if request.Limit > maxLimit {
+		request.Limit = maxLimit
+	}
+
+	if token := query.Get("cursor"); token != "" {
+		cursor, err := s.Decode(token)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Scope != scope {
+			return nil, ErrInvalidCursor
+		}
+		request.Cursor = cursor
+	}
+
+	return request, nil
+}
+
+// NewPage wraps one page of data, the output of ToApiData, in the standard
+// envelope with cursors and links to the neighbouring pages of r
+func (s *CursorSigner) NewPage(r *http.Request, data interface{}, bounds PageBounds, total *int) *Page {
+	page := &Page{
+		Data:  data,
+		Total: total,
+		Links: PageLinks{
+			Self: r.URL.RequestURI(),
+		},
+	}
+
+	// An empty page has no keys to continue from
+	if bounds.FirstKey == "" && bounds.LastKey == "" {
+		return page
+	}
+
+	if bounds.HasNext {
+		page.Next = s.Encode(Cursor{Scope: bounds.Scope, Key: bounds.LastKey})
+		page.Links.Next = withQuery(r.URL, "cursor", page.Next)
+	}
+
+	if bounds.HasPrev {
+		page.Prev = s.Encode(Cursor{Scope: bounds.Scope, Key: bounds.FirstKey, Backward: true})
+		page.Links.Prev = withQuery(r.URL, "cursor", page.Prev)
+	}
+
+	return page
+}
+
+func withQuery(u *url.URL, key, value string) string {
+	linked := *u
+	query := linked.Query()
+	query.Set(key, value)
+	linked.RawQuery = query.Encode()
+
+	return linked.RequestURI()
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCursorSigner(t *testing.T) {
+	signer := NewCursorSigner([]byte("secret"))
+	cursor := Cursor{Scope: "carriers/acme:", Key: "acme:42", Backward: true}
+
+	token := signer.Encode(cursor)
+	decoded, err := signer.Decode(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != cursor {
+		t.Errorf("got %+v, want %+v", decoded, cursor)
+	}
+
+	payload := strings.SplitN(token, ".", 2)[0]
+	forged := NewCursorSigner([]byte("other")).Encode(cursor)
+
+	for _, invalid := range []string{
+		"",
+		"no-separator",
+		payload + ".",
+		payload + ".!!!",
+		"!!!." + strings.SplitN(token, ".", 2)[1],
+		forged,
+		strings.SplitN(signer.Encode(Cursor{Key: "x"}), ".", 2)[0] + "." + strings.SplitN(token, ".", 2)[1],
+	} {
+		if _, err := signer.Decode(invalid); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decoded %q: got %v, want %v", invalid, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestParsePageRequest(t *testing.T) {
+	signer := NewCursorSigner([]byte("secret"))
+	token := signer.Encode(Cursor{Scope: "carriers", Key: "c5"})
+	otherScope := signer.Encode(Cursor{Scope: "trucks", Key: "t5"})
+
+	tests := []struct {
+		name      string
+		query     string
+		wantLimit int
+		wantKey   string
+		wantErr   bool
+	}{
+		{"defaults", "", 20, "", false},
+		{"limit", "limit=5", 5, "", false},
+		{"capped limit", "limit=500", 100, "", false},
+		{"invalid limit", "limit=abc", 0, "", true},
+		{"zero limit", "limit=0", 0, "", true},
+		{"cursor", "cursor=" + token, 20, "c5", false},
+		{"cursor of another scope", "cursor=" + otherScope, 0, "", true},
+		{"tampered cursor", "cursor=" + token + "x", 0, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request, err := signer.ParsePageRequest(query, "carriers", 20, 100)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", request)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if request.Limit != test.wantLimit {
+				t.Errorf("got limit %d, want %d", request.Limit, test.wantLimit)
+			}
+			key := ""
+			if request.Cursor != nil {
+				key = request.Cursor.Key
+			}
+			if key != test.wantKey {
+				t.Errorf("got cursor key %q, want %q", key, test.wantKey)
+			}
+		})
+	}
+}
+
+func TestNewPage(t *testing.T) {
+	signer := NewCursorSigner([]byte("secret"))
+	request := httptest.NewRequest(http.MethodGet, "/carriers?limit=2&fields=name", nil)
+
+	page := signer.NewPage(request, []interface{}{"a", "b"}, PageBounds{
+		Scope:    "carriers",
+		FirstKey: "c3",
+		LastKey:  "c4",
+		HasNext:  true,
+		HasPrev:  true,
+	}, nil)
+
+	if page.Links.Self != "/carriers?limit=2&fields=name" {
+		t.Errorf("got self link %q", page.Links.Self)
+	}
+
+	next, err := signer.Decode(page.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *next != (Cursor{Scope: "carriers", Key: "c4"}) {
+		t.Errorf("got next cursor %+v", next)
+	}
+	prev, err := signer.Decode(page.Prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *prev != (Cursor{Scope: "carriers", Key: "c3", Backward: true}) {
+		t.Errorf("got prev cursor %+v", prev)
+	}
+
+	// Links keep the other query parameters and round trip through ParsePageRequest
+	link, err := url.Parse(page.Links.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Query().Get("fields") != "name" || link.Query().Get("limit") != "2" {
+		t.Errorf("got next link %q", page.Links.Next)
+	}
+	parsed, err := signer.ParsePageRequest(link.Query(), "carriers", 20, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Cursor == nil || parsed.Cursor.Key != "c4" {
+		t.Errorf("got %+v from the next link", parsed.Cursor)
+	}
+
+	// Empty pages and the last page have no cursors
+	for _, bounds := range []PageBounds{{HasNext: true, HasPrev: true}, {FirstKey: "c5", LastKey: "c6"}} {
+		page := signer.NewPage(request, []interface{}{}, bounds, nil)
+		if page.Next != "" || page.Prev != "" || page.Links.Next != "" || page.Links.Prev != "" {
+			t.Errorf("got cursors %+v for %+v", page, bounds)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+)
+
+type marshalerKind int
+
+const (
+	noMarshaler marshalerKind = iota
+	timeMarshaler
+	apiMarshaler
+	jsonMarshaler
+	textMarshaler
+	stringMarshaler
+)
+
+// typePlan is everything about a type that rendering needs, worked out once
+// per type instead of on every value
+type typePlan struct {
+	// Struct fields to render, for struct types
+	fields []field
+	// The same fields sorted by name, the order encoding/json writes maps in
+	namedFields []field
+	// How values of the type are marshaled
+	marshaler marshalerKind
+	// How addressable values are marshaled through their pointer receiver
+	addrMarshaler marshalerKind
+	// The first problem found with the api tags of the struct's own fields
+	err error
+}
+
+var typePlans sync.Map // map[reflect.Type]*typePlan
+
+func planFor(t reflect.Type) *typePlan {
+	if plan, ok := typePlans.Load(t); ok {
+		return plan.(*typePlan)
+	}
+
+	plan := &typePlan{
+		marshaler: marshalerKindOf(t),
+	}
+	if t.Kind() != reflect.Ptr {
+		plan.addrMarshaler = marshalerKindOf(reflect.PtrTo(t))
+	}
+	if t.Kind() == reflect.Struct {
+		plan.fields, plan.err = typeFields(t)
+
+		plan.namedFields = append([]field(nil), plan.fields...)
+		sort.Slice(plan.namedFields, func(i, j int) bool {
+			return plan.namedFields[i].name < plan.namedFields[j].name
+		})
+	}
+
+	actual, _ := typePlans.LoadOrStore(t, plan)
+
+	return actual.(*typePlan)
+}
+
+var tagErrors sync.Map // map[reflect.Type]error
+
+// CheckTags reports the first problem with the api tags of the struct types
+// example is made of, such as an unknown mask. ToApiData can't return errors,
+// so it renders a field with a mistyped mask as null instead; Encoder returns
+// the error before writing anything. Call CheckTags when a type is registered
+// or at startup to find such problems early.
+func CheckTags(example interface{}) error {
+	t := reflect.TypeOf(example)
+	if t == nil {
+		return nil
+	}
+	if checked, ok := tagErrors.Load(t); ok {
+		err, _ := checked.(error)
+		return err
+	}
+
+	err := checkTags(t, map[reflect.Type]bool{})
+	tagErrors.Store(t, err)
+
+	return err
+}
+
+// checkTags walks the types reachable from t, planning each struct type
+func checkTags(t reflect.Type, visited map[reflect.Type]bool) error {
+	if visited[t] {
+		return nil
+	}
+	visited[t] = true
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return checkTags(t.Elem(), visited)
+	case reflect.Struct:
+		plan := planFor(t)
+		if plan.err != nil {
+			return plan.err
+		}
+		for _, f := range plan.fields {
+			if err := checkTags(f.typ, visited); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// cachedTypeFields is typeFields, cached
+func cachedTypeFields(t reflect.Type) []field {
+	return planFor(t).fields
+}
+
+func marshalerKindOf(t reflect.Type) marshalerKind {
+	switch {
+	case t == timeType:
+		return timeMarshaler
+	case t.Implements(apiMarshalerType):
+		return apiMarshaler
+	case t.Implements(jsonMarshalerType):
+		return jsonMarshaler
+	case t.Implements(textMarshalerType):
+		return textMarshaler
+	case t.Implements(stringerType) && isOpaque(t):
+		return stringMarshaler
+	}
+	return noMarshaler
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanForIsCached(t *testing.T) {
+	t1 := reflect.TypeOf(benchCarrier{})
+	if planFor(t1) != planFor(t1) {
+		t.Error("got a new plan for a planned type")
+	}
+
+	plan := planFor(t1)
+	for i := 1; i < len(plan.namedFields); i++ {
+		if plan.namedFields[i-1].name > plan.namedFields[i].name {
+			t.Errorf("named fields out of order: %s before %s", plan.namedFields[i-1].name, plan.namedFields[i].name)
+		}
+	}
+}
+
+func TestGeneratedToApiDataMatchesReflection(t *testing.T) {
+	carriers := benchCarriers()[:6]
+
+	for _, carrier := range carriers {
+		reflected := ToApiData(carrier)
+		generated := ToApiData(benchGeneratedCarrier(carrier))
+		if !reflect.DeepEqual(reflected, generated) {
+			t.Errorf("generated %#v, reflection rendered %#v", generated, reflected)
+		}
+	}
+
+	// Generated fields are renamed like reflected ones
+	reflected, err := ToApiDataWithOptions(carriers[0], Options{Naming: SnakeCase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := ToApiDataWithOptions(benchGeneratedCarrier(carriers[0]), Options{Naming: SnakeCase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reflected, generated) {
+		t.Errorf("generated %#v, reflection rendered %#v", generated, reflected)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	var nilPointer *int
+
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{nil, true},
+		{"", true},
+		{"a", false},
+		{0, true},
+		{uint(1), false},
+		{0.0, true},
+		{false, true},
+		{nilPointer, true},
+		{[]int{}, true},
+		{map[string]int{"a": 1}, false},
+		{struct{}{}, false},
+	}
+
+	for _, test := range tests {
+		if got := IsEmpty(test.value); got != test.want {
+			t.Errorf("IsEmpty(%#v): got %v, want %v", test.value, got, test.want)
+		}
+	}
+}
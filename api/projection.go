@@ -0,0 +1,202 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Projection narrows the API representation to a sparse set of fields and
+// expands fields tagged `api:"expandable"`, which are otherwise left out.
+// Paths use the rendered field names, or map keys, separated by dots. Slices
+// are transparent, so "carriers.name" selects the name of every carrier.
+type Projection struct {
+	fields fieldTree
+	expand fieldTree
+}
+
+// fieldTree is a set of field paths. A leaf selects the whole field.
+type fieldTree map[string]fieldTree
+
+// UnknownFieldsError is returned for projections naming fields that don't
+// exist, or that the viewer can't see
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("unknown fields: %s", strings.Join(e.Fields, ", "))
+}
+
+// ParseProjection reads a projection from the "fields" and "expand" query
+// parameters, e.g. ?fields=id,name,address.city&expand=carrier. Each may be
+// given more than once.
+func ParseProjection(query url.Values) (*Projection, error) {
+	return NewProjection(splitPaths(query["fields"]), splitPaths(query["expand"]))
+}
+
+// NewProjection returns a Projection selecting fields and expanding expand.
+// No fields means all fields.
+func NewProjection(fields, expand []string) (*Projection, error) {
+	fieldsTree, err := newFieldTree(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	expandTree, err := newFieldTree(expand)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Projection{
+		fields: fieldsTree,
+		expand: expandTree,
+	}, nil
+}
+
+// ToApiDataProjected is ToApiDataFor narrowed and expanded by projection. The
+// projection is checked against the type of data, and an
+// *UnknownFieldsError is returned for paths that can't match.
+func ToApiDataProjected(data interface{}, viewer Viewer, projection *Projection) (interface{}, error) {
+	return ToApiDataWithOptions(data, Options{
+		Viewer:     viewer,
+		Projection: projection,
+	})
+}
+
+// validateProjection checks the paths of projection against the type of data
+func (e *encodeState) validateProjection(data interface{}, projection *Projection) error {
+	if projection == nil {
+		return nil
+	}
+
+	var unknown []string
+	e.validatePaths(reflect.TypeOf(data), projection.fields, "", &unknown)
+	e.validatePaths(reflect.TypeOf(data), projection.expand, "", &unknown)
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return &UnknownFieldsError{Fields: unknown}
+	}
+
+	return nil
+}
+
+func splitPaths(values []string) []string {
+	var paths []string
+	for _, value := range values {
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+func newFieldTree(paths []string) (fieldTree, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	tree := fieldTree{}
+
+	for _, path := range paths {
+		node := tree
+		for _, segment := range strings.Split(path, ".") {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid field path %q", path)
+			}
+
+			child, ok := node[segment]
+			if !ok || child == nil {
+				child = fieldTree{}
+				node[segment] = child
+			}
+			node = child
+		}
+	}
+
+	return tree, nil
+}
+
+// validatePaths collects the paths in tree that can't exist in the rendering
+// of t. Anything below values without a static shape, such as interfaces or
+// types with custom marshalers, is accepted.
+func (e *encodeState) validatePaths(t reflect.Type, tree fieldTree, prefix string, unknown *[]string) {
+	if len(tree) == 0 || t == nil {
+		return
+	}
+
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		if hasCustomRepresentation(t) {
+			return
+		}
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Interface || hasCustomRepresentation(t) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		// Keys are data, so any key may be selected
+		for name, subtree := range tree {
+			e.validatePaths(t.Elem(), subtree, prefix+name+".", unknown)
+		}
+	case reflect.Struct:
+		fields := map[string]field{}
+		for _, f := range cachedTypeFields(t) {
+			if e.canView(f.roles) {
+				fields[e.fieldName(f)] = f
+			}
+		}
+
+		for name, subtree := range tree {
+			f, ok := fields[name]
+			if !ok {
+				*unknown = append(*unknown, prefix+name)
+				continue
+			}
+
+			e.validatePaths(f.typ, subtree, prefix+name+".", unknown)
+		}
+	default:
+		for name := range tree {
+			*unknown = append(*unknown, prefix+name)
+		}
+	}
+}
+
+func hasCustomRepresentation(t reflect.Type) bool {
+	plan := planFor(t)
+	return plan.marshaler != noMarshaler || plan.addrMarshaler != noMarshaler
+}
+
+// project keeps only the selected keys of rendered objects
+func project(rendered interface{}, tree fieldTree) interface{} {
+	if len(tree) == 0 {
+		return rendered
+	}
+
+	switch value := rendered.(type) {
+	case map[string]interface{}:
+		projected := make(map[string]interface{}, len(tree))
+		for name, subtree := range tree {
+			if child, ok := value[name]; ok {
+				projected[name] = project(child, subtree)
+			}
+		}
+		return projected
+	case []interface{}:
+		projected := make([]interface{}, len(value))
+		for i, child := range value {
+			projected[i] = project(child, tree)
+		}
+		return projected
+	default:
+		return rendered
+	}
+}
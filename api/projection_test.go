@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+type projectionAddress struct {
+	City  string `json:"city"`
+	State string `json:"state"`
+}
+
+type projectionOwner struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type projectionTruck struct {
+	Plate string `json:"plate"`
+	Year  int    `json:"year"`
+}
+
+type projectionCarrier struct {
+	ID      int                    `json:"id"`
+	Name    string                 `json:"name"`
+	Address projectionAddress      `json:"address"`
+	Trucks  []projectionTruck      `json:"trucks"`
+	Owner   *projectionOwner       `json:"owner" api:"expandable"`
+	Notes   string                 `json:"notes" api:"roles=admin"`
+	Extra   map[string]interface{} `json:"extra"`
+}
+
+func newProjectionCarrier() projectionCarrier {
+	return projectionCarrier{
+		ID:      1,
+		Name:    "Acme",
+		Address: projectionAddress{City: "Springfield", State: "IL"},
+		Trucks:  []projectionTruck{{Plate: "A1", Year: 2020}, {Plate: "B2", Year: 2021}},
+		Owner:   &projectionOwner{ID: 9, Name: "Pat"},
+		Notes:   "n",
+		Extra:   map[string]interface{}{"color": "red", "size": 3},
+	}
+}
+
+func TestToApiDataProjected(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "fields",
+			query: "fields=id,name",
+			want:  map[string]interface{}{"id": 1, "name": "Acme"},
+		},
+		{
+			name:  "nested fields and repeated parameters",
+			query: "fields=address.city&fields=trucks.plate",
+			want: map[string]interface{}{
+				"address": map[string]interface{}{"city": "Springfield"},
+				"trucks":  []interface{}{map[string]interface{}{"plate": "A1"}, map[string]interface{}{"plate": "B2"}},
+			},
+		},
+		{
+			name:  "map keys",
+			query: "fields=extra.color",
+			want:  map[string]interface{}{"extra": map[string]interface{}{"color": "red"}},
+		},
+		{
+			name:  "expand",
+			query: "fields=id,owner&expand=owner",
+			want:  map[string]interface{}{"id": 1, "owner": map[string]interface{}{"id": 9, "name": "Pat"}},
+		},
+		{
+			name:  "expandable field selected but not expanded",
+			query: "fields=id,owner",
+			want:  map[string]interface{}{"id": 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			projection, err := ParseProjection(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rendered, err := ToApiDataProjected(newProjectionCarrier(), nil, projection)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rendered, test.want) {
+				t.Errorf("got %#v, want %#v", rendered, test.want)
+			}
+		})
+	}
+}
+
+func TestToApiDataProjectedWithoutFields(t *testing.T) {
+	projection, err := NewProjection(nil, []string{"owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rendered, err := ToApiDataProjected(newProjectionCarrier(), nil, projection)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"address", "extra", "id", "name", "owner", "trucks"}
+	if keys := sortedKeys(rendered.(map[string]interface{})); !reflect.DeepEqual(keys, want) {
+		t.Errorf("got fields %v, want %v", keys, want)
+	}
+}
+
+func TestToApiDataProjectedUnknownFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		expand []string
+		viewer Viewer
+		want   []string
+	}{
+		{"unknown field", []string{"id", "missing"}, nil, nil, []string{"missing"}},
+		{"unknown nested field", []string{"address.zip", "trucks.vin"}, nil, nil, []string{"address.zip", "trucks.vin"}},
+		{"below a scalar", []string{"name.first"}, nil, nil, []string{"name.first"}},
+		{"unknown expansion", nil, []string{"carrier"}, nil, []string{"carrier"}},
+		{"hidden from the viewer", []string{"notes"}, nil, nil, []string{"notes"}},
+		{"visible to the viewer", []string{"notes"}, nil, testViewer{"admin"}, nil},
+		{"anything below a map", []string{"extra.color.shade"}, nil, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			projection, err := NewProjection(test.fields, test.expand)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ToApiDataProjected(newProjectionCarrier(), test.viewer, projection)
+
+			var unknown *UnknownFieldsError
+			if test.want == nil {
+				if err != nil {
+					t.Errorf("got %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &unknown) {
+				t.Fatalf("got %v, want an UnknownFieldsError", err)
+			}
+			if !reflect.DeepEqual(unknown.Fields, test.want) {
+				t.Errorf("got unknown fields %v, want %v", unknown.Fields, test.want)
+			}
+		})
+	}
+}
+
+func TestNewProjectionRejectsEmptySegments(t *testing.T) {
+	for _, path := range []string{"address.", ".id", "a..b"} {
+		if _, err := NewProjection([]string{path}, nil); err == nil {
+			t.Errorf("accepted %q", path)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// RedactionHashKey keys the digests of fields tagged `api:"hash"`, so they
+// can't be reversed by hashing guesses. It must be set before such fields are
+// rendered; until it is, they're masked entirely instead.
+var RedactionHashKey []byte
+
+// redaction hides a field's value from viewers who may not see it in full.
+// Fields are tagged with one of:
+//
+//	api:"mask=last4"  keep the last 4 characters, e.g. *******1234
+//	api:"mask=email"  keep the first character and the domain, e.g. j***@example.com
+//	api:"mask=phone"  keep the last 4 digits and the formatting, e.g. (***) ***-1234
+//	api:"mask=all"    replace every character
+//	api:"hash"        replace the value with a digest, so equal values still match
+//	api:"redact"      replace the value with null
+//
+// and may add `unmask=admin,owner` for the roles that see the original value.
+// Strings inside slices, maps and structs are transformed individually.
+// Viewers who can't see a field's original value can't write it either.
+type redaction struct {
+	mode   string
+	mask   string
+	unmask []string
+}
+
+// parseRedaction returns the redaction a field's api tag asks for, or nil.
+// When the tag is invalid it returns one that replaces the value with null for
+// every viewer along with the error.
+func parseRedaction(tag apiTag) (*redaction, error) {
+	r := &redaction{
+		unmask: tag.list("unmask"),
+	}
+
+	switch {
+	case tag.has("redact"):
+		r.mode = "redact"
+	case tag.has("hash"):
+		r.mode = "hash"
+	case tag.has("mask"):
+		r.mode = "mask"
+		r.mask = tag["mask"]
+		switch r.mask {
+		case "last4", "email", "phone", "all":
+		default:
+			return &redaction{mode: "redact"}, fmt.Errorf("unknown mask %q", r.mask)
+		}
+	default:
+		return nil, nil
+	}
+
+	return r, nil
+}
+
+// redacted applies the field's redaction to its rendered value unless the
+// viewer is allowed to see it
+func (e *encodeState) redacted(r *redaction, rendered interface{}) interface{} {
+	if e.canUnmask(r) {
+		return rendered
+	}
+
+	if r.mode == "redact" {
+		return nil
+	}
+
+	return r.transform(rendered)
+}
+
+// canUnmask reports whether the viewer sees the original value of a field
+// with redaction r
+func (e *encodeState) canUnmask(r *redaction) bool {
+	return r == nil || (len(r.unmask) > 0 && e.hasAnyRole(r.unmask))
+}
+
+func (r *redaction) transform(rendered interface{}) interface{} {
+	switch value := rendered.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		transformed := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			transformed[key] = r.transform(child)
+		}
+		return transformed
+	case []interface{}:
+		transformed := make([]interface{}, len(value))
+		for i, child := range value {
+			transformed[i] = r.transform(child)
+		}
+		return transformed
+	case string:
+		return r.transformString(value)
+	default:
+		return r.transformString(fmt.Sprint(value))
+	}
+}
+
+func (r *redaction) transformString(value string) string {
+	if r.mode == "hash" {
+		// A plain digest could be reversed by hashing guesses
+		if len(RedactionHashKey) == 0 {
+			return maskRunes(value, 0)
+		}
+
+		mac := hmac.New(sha256.New, RedactionHashKey)
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	switch r.mask {
+	case "email":
+		at := strings.LastIndex(value, "@")
+		if at <= 0 {
+			return maskRunes(value, 0)
+		}
+		local := []rune(value[:at])
+		return string(local[0]) + strings.Repeat("*", len(local)-1) + value[at:]
+	case "phone":
+		return maskDigits(value, 4)
+	case "all":
+		return maskRunes(value, 0)
+	default:
+		return maskRunes(value, 4)
+	}
+}
+
+// maskRunes replaces all but the last keep characters. Values that aren't
+// longer than keep are replaced entirely, since keeping them would show them
+// in full.
+func maskRunes(value string, keep int) string {
+	runes := []rune(value)
+	if len(runes) <= keep {
+		keep = 0
+	}
+
+	for i := 0; i < len(runes)-keep; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// maskDigits replaces all but the last keep digits, leaving formatting alone.
+// Like maskRunes, every digit is replaced when there are no more than keep.
+func maskDigits(value string, keep int) string {
+	runes := []rune(value)
+
+	total := 0
+	for _, r := range runes {
+		if unicode.IsDigit(r) {
+			total++
+		}
+	}
+	if total <= keep {
+		keep = 0
+	}
+
+	digits := 0
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsDigit(runes[i]) {
+			continue
+		}
+		digits++
+		if digits > keep {
+			runes[i] = '*'
+		}
+	}
+
+	return string(runes)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type redactCarrier struct {
+	Account string   `json:"account" api:"mask=last4;unmask=admin"`
+	Email   string   `json:"email" api:"mask=email"`
+	Phone   string   `json:"phone" api:"mask=phone"`
+	PIN     string   `json:"pin" api:"mask=all"`
+	Tax     string   `json:"tax" api:"hash"`
+	Notes   string   `json:"notes" api:"redact;unmask=admin"`
+	Aliases []string `json:"aliases" api:"mask=last4"`
+}
+
+func TestMasks(t *testing.T) {
+	tests := []struct {
+		name  string
+		mask  string
+		value string
+		want  string
+	}{
+		{"last4", "last4", "4111111111111234", "************1234"},
+		{"last4 short", "last4", "1234", "****"},
+		{"last4 very short", "last4", "12", "**"},
+		{"email", "email", "jane@example.com", "j***@example.com"},
+		{"email without local part", "email", "@example.com", "************"},
+		{"phone", "phone", "(555) 867-1234", "(***) ***-1234"},
+		{"phone short", "phone", "12-34", "**-**"},
+		{"all", "all", "secret", "******"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &redaction{mode: "mask", mask: test.mask}
+			if got := r.transformString(test.value); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHashRedaction(t *testing.T) {
+	defer func(key []byte) { RedactionHashKey = key }(RedactionHashKey)
+
+	r := &redaction{mode: "hash"}
+
+	RedactionHashKey = nil
+	if got := r.transformString("12-3456789"); got != "**********" {
+		t.Errorf("got %q without a key, want the value masked", got)
+	}
+
+	RedactionHashKey = []byte("key")
+	first, second := r.transformString("12-3456789"), r.transformString("12-3456789")
+	if first != second || len(first) != 64 {
+		t.Errorf("got digests %q and %q", first, second)
+	}
+	if r.transformString("98-7654321") == first {
+		t.Error("different values have the same digest")
+	}
+
+	RedactionHashKey = []byte("other key")
+	if r.transformString("12-3456789") == first {
+		t.Error("the digest doesn't depend on the key")
+	}
+}
+
+func TestRedactedFields(t *testing.T) {
+	defer func(key []byte) { RedactionHashKey = key }(RedactionHashKey)
+	RedactionHashKey = nil
+
+	carrier := redactCarrier{
+		Account: "4111111111111234",
+		Email:   "jane@example.com",
+		Phone:   "555-867-1234",
+		PIN:     "9876",
+		Tax:     "12-3456789",
+		Notes:   "late payer",
+		Aliases: []string{"acme-0001", "a1"},
+	}
+
+	got := ToApiDataFor(carrier, testViewer{"owner"})
+	want := map[string]interface{}{
+		"account": "************1234",
+		"email":   "j***@example.com",
+		"phone":   "***-***-1234",
+		"pin":     "****",
+		"tax":     "**********",
+		"notes":   nil,
+		"aliases": []interface{}{"*****0001", "**"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	admin := ToApiDataFor(carrier, testViewer{"admin"}).(map[string]interface{})
+	if admin["account"] != carrier.Account || admin["notes"] != carrier.Notes {
+		t.Errorf("got %v, want the account and notes unmasked for admin", admin)
+	}
+	if admin["pin"] != "****" {
+		t.Errorf("got pin %v, want it masked for every viewer", admin["pin"])
+	}
+}
+
+func TestRedactionTags(t *testing.T) {
+	type invalid struct {
+		Account string `json:"account" api:"mask=bogus"`
+	}
+
+	err := CheckTags(invalid{})
+	if err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("got %v, want an unknown mask error", err)
+	}
+
+	// Fields with invalid tags are hidden rather than shown in full
+	got := ToApiData(invalid{Account: "4111"}).(map[string]interface{})
+	if got["account"] != nil {
+		t.Errorf("got account %v, want null", got["account"])
+	}
+}
+
+func TestDecodeRedactedFields(t *testing.T) {
+	data := []byte(`{"account": "4111111111111234", "pin": "1234"}`)
+
+	// The masked value a viewer was shown mustn't overwrite the original
+	var carrier redactCarrier
+	fields := decodeErrorFields(t, FromApiDataFor(data, &carrier, testViewer{"owner"}))
+	want := map[string]string{"account": "is read-only", "pin": "is read-only"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v, want %v", fields, want)
+	}
+
+	carrier = redactCarrier{}
+	if err := FromApiDataFor([]byte(`{"account": "4111111111111234"}`), &carrier, testViewer{"admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if carrier.Account != "4111111111111234" {
+		t.Errorf("got account %q, want it written by admin", carrier.Account)
+	}
+}
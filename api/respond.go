@@ -0,0 +1,321 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Response formats supported by Respond
+const (
+	FormatJSON       = "json"
+	FormatPrettyJSON = "pretty"
+	FormatNDJSON     = "ndjson"
+	FormatCSV        = "csv"
+)
+
+var formatContentTypes = map[string]string{
+	FormatJSON:       "application/json",
+	FormatPrettyJSON: "application/json",
+	FormatNDJSON:     "application/x-ndjson",
+	FormatCSV:        "text/csv; charset=utf-8",
+}
+
+var mediaTypeFormats = map[string]string{
+	"application/json":     FormatJSON,
+	"application/x-ndjson": FormatNDJSON,
+	"application/ndjson":   FormatNDJSON,
+	"text/csv":             FormatCSV,
+	"application/*":        FormatJSON,
+	"*/*":                  FormatJSON,
+}
+
+// NegotiateFormat picks the response format for r. A format query parameter
+// takes precedence over the Accept header, and JSON is used when neither is
+// given. It reports false if the client only accepts unsupported formats.
+func NegotiateFormat(r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, ok := formatContentTypes[format]
+		return format, ok
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return FormatJSON, true
+	}
+
+	type candidate struct {
+		format  string
+		quality float64
+	}
+
+	var candidates []candidate
+	for _, entry := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+
+		format, ok := mediaTypeFormats[mediaType]
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = parsed
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		candidates = append(candidates, candidate{format: format, quality: quality})
+	}
+
+	if len(candidates) == 0 {
+		return FormatJSON, false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	return candidates[0].format, true
+}
+
+// Respond writes data, the output of ToApiData, in the format negotiated for
+// r. Lists are written one record per line for NDJSON and one record per row
+// for CSV, where nested keys are flattened into dotted column names. CSV cells
+// holding strings that spreadsheets would run as formulas are prefixed with a
+// quote. If data can't be encoded, a 500 is written in the same format.
+func Respond(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	format, ok := NegotiateFormat(r)
+	if !ok {
+		writeFormatted(w, FormatJSON, http.StatusNotAcceptable, errorBody(fmt.Errorf("none of the accepted formats are supported")))
+		return
+	}
+
+	writeFormatted(w, format, status, data)
+}
+
+// RespondError writes err in the format negotiated for r. Field-level
+// problems from ValidationErrors are included.
+func RespondError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	format, ok := NegotiateFormat(r)
+	if !ok {
+		format = FormatJSON
+	}
+
+	writeFormatted(w, format, status, errorBody(err))
+}
+
+func errorBody(err error) interface{} {
+	body := map[string]interface{}{
+		"error": err.Error(),
+	}
+
+	var validationErrors ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make([]interface{}, len(validationErrors))
+		for i, fieldError := range validationErrors {
+			fields[i] = map[string]interface{}{
+				"field":   fieldError.Field,
+				"message": fieldError.Message,
+			}
+		}
+		body["fields"] = fields
+	}
+
+	return body
+}
+
+func writeFormatted(w http.ResponseWriter, format string, status int, data interface{}) {
+	body, err := encodeFormatted(format, data)
+	if err != nil {
+		status = http.StatusInternalServerError
+
+		errorData := errorBody(err)
+		if body, err = encodeFormatted(format, errorData); err != nil {
+			format = FormatJSON
+			body, _ = encodeFormatted(format, errorData)
+		}
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func encodeFormatted(format string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var err error
+	switch format {
+	case FormatPrettyJSON:
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(data)
+	case FormatNDJSON:
+		err = writeNDJSON(&buf, data)
+	case FormatCSV:
+		err = writeCSV(&buf, data)
+	default:
+		err = json.NewEncoder(&buf).Encode(data)
+	}
+
+	return buf.Bytes(), err
+}
+
+func writeNDJSON(buf *bytes.Buffer, data interface{}) error {
+	records, ok := data.([]interface{})
+	if !ok {
+		records = []interface{}{data}
+	}
+
+	encoder := json.NewEncoder(buf)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCSV(buf *bytes.Buffer, data interface{}) error {
+	records, ok := data.([]interface{})
+	if !ok {
+		records = []interface{}{data}
+	}
+
+	rows := make([]map[string]string, len(records))
+	columns := map[string]struct{}{}
+
+	for i, record := range records {
+		rows[i] = map[string]string{}
+		if err := flatten(record, "", rows[i]); err != nil {
+			return err
+		}
+		for column := range rows[i] {
+			columns[column] = struct{}{}
+		}
+	}
+
+	header := make([]string, 0, len(columns))
+	for column := range columns {
+		header = append(header, column)
+	}
+	sort.Strings(header)
+
+	// A null in one record shouldn't add a column for an object that other
+	// records flatten into nested columns
+	kept := make([]string, 0, len(header))
+	for _, column := range header {
+		isParent := false
+		for _, other := range header {
+			if strings.HasPrefix(other, column+".") {
+				isParent = true
+				break
+			}
+		}
+		if !isParent {
+			kept = append(kept, column)
+		}
+	}
+	header = kept
+
+	writer := csv.NewWriter(buf)
+
+	// Column names come from map keys, which may be data too
+	escapedHeader := make([]string, len(header))
+	for i, column := range header {
+		escapedHeader[i] = escapeFormula(column)
+	}
+	if err := writer.Write(escapedHeader); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		line := make([]string, len(header))
+		for i, column := range header {
+			line[i] = row[column]
+		}
+		if err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// flatten writes every leaf of value into row, keyed by its dotted path
+func flatten(value interface{}, prefix string, row map[string]string) error {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if err := flatten(child, join(key), row); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			if err := flatten(child, join(strconv.Itoa(i)), row); err != nil {
+				return err
+			}
+		}
+	case nil:
+		row[columnName(prefix)] = ""
+	case string:
+		row[columnName(prefix)] = escapeFormula(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		// Named string types and the like are still written without quotes
+		if unquoted, err := strconv.Unquote(string(encoded)); err == nil {
+			row[columnName(prefix)] = escapeFormula(unquoted)
+		} else {
+			row[columnName(prefix)] = string(encoded)
+		}
+	}
+
+	return nil
+}
+
+// escapeFormula prefixes strings that spreadsheets would treat as formulas
+// with a quote, so they're shown as text instead of being run. Numbers are
+// written as they are.
+func escapeFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// Scalars at the top level go in a column named "value"
+func columnName(path string) string {
+	if path == "" {
+		return "value"
+	}
+	return path
+}
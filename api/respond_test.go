@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		want   string
+		wantOK bool
+	}{
+		{"default", "", "", FormatJSON, true},
+		{"query wins", "?format=csv", "application/json", FormatCSV, true},
+		{"unknown query format", "?format=xml", "", "xml", false},
+		{"accept", "", "text/csv", FormatCSV, true},
+		{"quality", "", "application/json;q=0.5, application/x-ndjson", FormatNDJSON, true},
+		{"first of equal quality", "", "text/csv, application/json", FormatCSV, true},
+		{"wildcard", "", "text/html, */*;q=0.1", FormatJSON, true},
+		{"refused", "", "text/csv;q=0, application/json", FormatJSON, true},
+		{"unsupported", "", "text/html", FormatJSON, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/"+test.query, nil)
+			if test.accept != "" {
+				request.Header.Set("Accept", test.accept)
+			}
+
+			format, ok := NegotiateFormat(request)
+			if format != test.want || ok != test.wantOK {
+				t.Errorf("got %q, %v, want %q, %v", format, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+// respond calls Respond for a request with query and returns the response
+func respond(t *testing.T, query string, data interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	Respond(recorder, httptest.NewRequest(http.MethodGet, "/"+query, nil), http.StatusOK, data)
+	return recorder
+}
+
+func TestRespond(t *testing.T) {
+	records := []interface{}{
+		map[string]interface{}{"name": "Acme", "address": map[string]interface{}{"city": "Springfield"}, "fleet": 12},
+		map[string]interface{}{"name": "Bolt", "address": nil, "tags": []interface{}{"a", "b"}},
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+	}{
+		{"json", "", "application/json", `[{"address":{"city":"Springfield"},"fleet":12,"name":"Acme"},{"address":null,"name":"Bolt","tags":["a","b"]}]` + "\n"},
+		{"ndjson", "?format=ndjson", "application/x-ndjson", `{"address":{"city":"Springfield"},"fleet":12,"name":"Acme"}` + "\n" + `{"address":null,"name":"Bolt","tags":["a","b"]}` + "\n"},
+		{"csv", "?format=csv", "text/csv; charset=utf-8", "address.city,fleet,name,tags.0,tags.1\nSpringfield,12,Acme,,\n,,Bolt,a,b\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := respond(t, test.query, records)
+
+			if recorder.Code != http.StatusOK {
+				t.Errorf("got status %d", recorder.Code)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("got Content-Type %q, want %q", contentType, test.contentType)
+			}
+			if body := recorder.Body.String(); body != test.body {
+				t.Errorf("got body %q, want %q", body, test.body)
+			}
+		})
+	}
+
+	if recorder := respond(t, "?format=pretty", map[string]interface{}{"a": 1}); recorder.Body.String() != "{\n  \"a\": 1\n}\n" {
+		t.Errorf("got pretty body %q", recorder.Body.String())
+	}
+}
+
+func TestRespondCSVEscapesFormulas(t *testing.T) {
+	recorder := respond(t, "?format=csv", []interface{}{
+		map[string]interface{}{"a": "=SUM(A1:A2)", "b": "+1", "c": "-cmd", "d": "@x", "e": -5, "f": "safe"},
+		map[string]interface{}{"=key": "v"},
+	})
+
+	want := "'=key,a,b,c,d,e,f\n,'=SUM(A1:A2),'+1,'-cmd,'@x,-5,safe\nv,,,,,,\n"
+	if body := recorder.Body.String(); body != want {
+		t.Errorf("got %q, want %q", body, want)
+	}
+}
+
+func TestRespondEncodeErrors(t *testing.T) {
+	unencodable := map[string]interface{}{"ratio": math.NaN()}
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+	}{
+		{"json", "", "application/json"},
+		{"ndjson", "?format=ndjson", "application/x-ndjson"},
+		{"csv", "?format=csv", "text/csv; charset=utf-8"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := respond(t, test.query, unencodable)
+
+			if recorder.Code != http.StatusInternalServerError {
+				t.Errorf("got status %d, want %d", recorder.Code, http.StatusInternalServerError)
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("got Content-Type %q, want %q", contentType, test.contentType)
+			}
+			if !strings.Contains(recorder.Body.String(), "unsupported value") {
+				t.Errorf("got body %q, want the encoding error", recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRespondError(t *testing.T) {
+	err := ValidationErrors{{Field: "name", Message: "is required"}}
+
+	recorder := httptest.NewRecorder()
+	RespondError(recorder, httptest.NewRequest(http.MethodPost, "/", nil), http.StatusUnprocessableEntity, err)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d", recorder.Code)
+	}
+
+	var body struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "name is required" || len(body.Fields) != 1 || body.Fields[0] != err[0] {
+		t.Errorf("got %+v", body)
+	}
+
+	// Unsupported formats get a 406 in JSON
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Accept", "text/html")
+	recorder = httptest.NewRecorder()
+	Respond(recorder, request, http.StatusOK, "data")
+	if recorder.Code != http.StatusNotAcceptable || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("got status %d and Content-Type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	// Errors wrapping validation errors still list the fields
+	recorder = httptest.NewRecorder()
+	RespondError(recorder, httptest.NewRequest(http.MethodPost, "/", nil), http.StatusBadRequest, fmt.Errorf("decoding: %w", err))
+	if !strings.Contains(recorder.Body.String(), `"fields"`) {
+		t.Errorf("got %s, want the fields", recorder.Body.String())
+	}
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+)
+
+// apiTag holds the directives of an api struct tag. Directives are separated
+// by semicolons and may take a value, e.g. `api:"roles=admin,owner"`.
+type apiTag map[string]string
+
+func parseApiTag(tag string) apiTag {
+	directives := apiTag{}
+
+	for _, directive := range strings.Split(tag, ";") {
+		if name, value := splitDirective(directive); name != "" {
+			directives[name] = value
+		}
+	}
+
+	return directives
+}
+
+// parseValidateTag is parseApiTag for validate tags. A regex directive runs
+// to the end of the tag so its pattern may contain semicolons, e.g.
+// `validate:"required;regex=^[a-z;]+$"`.
+func parseValidateTag(tag string) apiTag {
+	directives := apiTag{}
+
+	for tag != "" {
+		directive, rest := tag, ""
+		if idx := strings.Index(tag, ";"); idx != -1 {
+			directive, rest = tag[:idx], tag[idx+1:]
+		}
+
+		name, value := splitDirective(directive)
+		if name == "regex" {
+			_, value = splitDirective(tag)
+			rest = ""
+		}
+		if name != "" {
+			directives[name] = value
+		}
+
+		tag = rest
+	}
+
+	return directives
+}
+
+// splitDirective splits a directive into its name and value
+func splitDirective(directive string) (string, string) {
+	directive = strings.TrimSpace(directive)
+	if idx := strings.Index(directive, "="); idx != -1 {
+		return strings.TrimSpace(directive[:idx]), strings.TrimSpace(directive[idx+1:])
+	}
+	return directive, ""
+}
+
+func (t apiTag) has(name string) bool {
+	_, ok := t[name]
+	return ok
+}
+
+// list returns the comma-separated values of a directive
+func (t apiTag) list(name string) []string {
+	var values []string
+	for _, value := range strings.Split(t[name], ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// tagOptions is the string following a comma in a struct field's json tag
+type tagOptions string
+
+// parseTag splits a struct field's json tag into its name and options
+func parseTag(tag string) (string, tagOptions) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tagOptions(tag[idx+1:])
+	}
+	return tag, tagOptions("")
+}
+
+// Contains reports whether a comma-separated list of options contains a
+// particular option
+func (o tagOptions) Contains(optionName string) bool {
+	if len(o) == 0 {
+		return false
+	}
+
+	s := string(o)
+	for s != "" {
+		var next string
+		if idx := strings.Index(s, ","); idx >= 0 {
+			s, next = s[:idx], s[idx+1:]
+		}
+		if s == optionName {
+			return true
+		}
+		s = next
+	}
+
+	return false
+}
+
+// isValidTag mirrors encoding/json, which ignores names it can't represent
+func isValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", c):
+			// Backslash and quote chars are reserved, but otherwise any
+			// punctuation chars are allowed in a tag name.
+		case !unicode.IsLetter(c) && !unicode.IsDigit(c):
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var validationPatterns sync.Map // map[string]*regexp.Regexp
+
+// validate checks a field against the rules in its validate tag
+func (d *decodeState) validate(v reflect.Value, f field, path string, supplied bool) {
+	rules := f.rules
+
+	if rules.has("required") && (!supplied || isEmptyValue(v)) {
+		d.fail(path, "is required")
+		return
+	}
+
+	// Optional fields that were left out don't need to satisfy anything else
+	if !supplied && isEmptyValue(v) {
+		return
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	if value, ok := rules["min"]; ok {
+		if below, err := compareBound(v, value, -1); err != nil {
+			d.fail(path, err.Error())
+		} else if below {
+			d.fail(path, boundMessage(v, "at least", value))
+		}
+	}
+
+	if value, ok := rules["max"]; ok {
+		if above, err := compareBound(v, value, 1); err != nil {
+			d.fail(path, err.Error())
+		} else if above {
+			d.fail(path, boundMessage(v, "at most", value))
+		}
+	}
+
+	if pattern, ok := rules["regex"]; ok && v.Kind() == reflect.String {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			d.fail(path, "has an invalid validation pattern")
+		} else if !re.MatchString(v.String()) {
+			d.fail(path, fmt.Sprintf("must match %s", pattern))
+		}
+	}
+
+	if rules.has("enum") {
+		allowed := rules.list("enum")
+		value := fmt.Sprint(scalarValue(v))
+
+		isAllowed := false
+		for _, candidate := range allowed {
+			if candidate == value {
+				isAllowed = true
+				break
+			}
+		}
+
+		if !isAllowed {
+			d.fail(path, fmt.Sprintf("must be one of %s", strings.Join(allowed, ", ")))
+		}
+	}
+}
+
+// compareBound reports whether v is beyond bound in direction, comparing
+// numbers by value and strings, slices and maps by length
+func compareBound(v reflect.Value, bound string, direction int) (bool, error) {
+	limit, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return false, fmt.Errorf("has an invalid validation bound")
+	}
+
+	var actual float64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		actual = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		actual = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		actual = v.Float()
+	case reflect.String:
+		actual = float64(len([]rune(v.String())))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		actual = float64(v.Len())
+	default:
+		return false, nil
+	}
+
+	if direction < 0 {
+		return actual < limit, nil
+	}
+	return actual > limit, nil
+}
+
+func boundMessage(v reflect.Value, qualifier, bound string) string {
+	switch v.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must be %s %s characters long", qualifier, bound)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return fmt.Sprintf("must have %s %s items", qualifier, bound)
+	}
+	return fmt.Sprintf("must be %s %s", qualifier, bound)
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := validationPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	validationPatterns.Store(pattern, re)
+
+	return re, nil
+}
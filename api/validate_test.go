@@ -0,0 +1,113 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+type validateCarrier struct {
+	Name   string            `json:"name" validate:"required;min=2;max=5"`
+	Fleet  int               `json:"fleet" validate:"min=1;max=100"`
+	Rating float64           `json:"rating" validate:"max=4.5"`
+	Code   string            `json:"code" validate:"regex=^[A-Z]{2}$"`
+	Route  string            `json:"route" validate:"min=1;regex=^[a-z]+(;[a-z]+)*$"`
+	State  string            `json:"state" validate:"enum=IL,WI, IN"`
+	Tags   []string          `json:"tags" validate:"max=2"`
+	Labels map[string]string `json:"labels" validate:"min=1"`
+	Owner  *string           `json:"owner" validate:"min=3"`
+	Broken string            `json:"broken" validate:"max=lots"`
+	BadRe  string            `json:"badRe" validate:"regex=[a-"`
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want map[string]string
+	}{
+		{
+			name: "valid",
+			data: `{"name": "Acme", "fleet": 12, "code": "AC", "route": "chi;mad", "state": "IN", "tags": ["a"], "labels": {"a": "b"}, "owner": "Pat"}`,
+		},
+		{
+			name: "optional fields left out",
+			data: `{"name": "Acme"}`,
+		},
+		{
+			name: "required",
+			data: `{"name": ""}`,
+			want: map[string]string{"name": "is required"},
+		},
+		{
+			name: "string length",
+			data: `{"name": "Ac€media"}`,
+			want: map[string]string{"name": "must be at most 5 characters long"},
+		},
+		{
+			name: "number bounds",
+			data: `{"name": "Acme", "fleet": 0, "rating": 4.6}`,
+			want: map[string]string{"fleet": "must be at least 1", "rating": "must be at most 4.5"},
+		},
+		{
+			name: "items",
+			data: `{"name": "Acme", "tags": ["a", "b", "c"], "labels": {}}`,
+			want: map[string]string{"tags": "must have at most 2 items", "labels": "must have at least 1 items"},
+		},
+		{
+			name: "pointer",
+			data: `{"name": "Acme", "owner": "Al"}`,
+			want: map[string]string{"owner": "must be at least 3 characters long"},
+		},
+		{
+			name: "regex",
+			data: `{"name": "Acme", "code": "ac", "route": "chi;"}`,
+			want: map[string]string{"code": "must match ^[A-Z]{2}$", "route": "must match ^[a-z]+(;[a-z]+)*$"},
+		},
+		{
+			name: "enum",
+			data: `{"name": "Acme", "state": "MI"}`,
+			want: map[string]string{"state": "must be one of IL, WI, IN"},
+		},
+		{
+			name: "invalid rules",
+			data: `{"name": "Acme", "broken": "a", "badRe": "a"}`,
+			want: map[string]string{"broken": "has an invalid validation bound", "badRe": "has an invalid validation pattern"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var carrier validateCarrier
+			err := FromApiData([]byte(test.data), &carrier)
+			if test.want == nil {
+				if err != nil {
+					t.Errorf("got %v", err)
+				}
+				return
+			}
+
+			if fields := decodeErrorFields(t, err); !reflect.DeepEqual(fields, test.want) {
+				t.Errorf("got %v, want %v", fields, test.want)
+			}
+		})
+	}
+}
+
+func TestParseValidateTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want apiTag
+	}{
+		{"", apiTag{}},
+		{"required; min=1 ;max=3", apiTag{"required": "", "min": "1", "max": "3"}},
+		{"required;regex=^a;b=c$", apiTag{"required": "", "regex": "^a;b=c$"}},
+		{"regex=^(a|b);?$", apiTag{"regex": "^(a|b);?$"}},
+		{"enum=a,b;regex=x", apiTag{"enum": "a,b", "regex": "x"}},
+	}
+
+	for _, test := range tests {
+		if got := parseValidateTag(test.tag); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseValidateTag(%q): got %v, want %v", test.tag, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import "reflect"
+
+// Viewer is the caller data is rendered for. *server.Principal from the
+// middleware package satisfies it.
+type Viewer interface {
+	HasRole(role string) bool
+}
+
+const (
+	// RolePublic is granted to every viewer, including anonymous ones
+	RolePublic = "public"
+	// RoleAuthenticated is granted to every non-nil viewer
+	RoleAuthenticated = "authenticated"
+)
+
+// ToApiDataFor is ToApiData rendered for viewer. Fields tagged with
+// `api:"roles=admin,owner"` are only included if the viewer has at least one
+// of the listed roles. A nil viewer is anonymous.
+func ToApiDataFor(data interface{}, viewer Viewer) interface{} {
+	return newEncodeState(Options{Viewer: viewer}).value(reflect.ValueOf(data))
+}
+
+// canView reports whether the viewer satisfies every role set
+func (e *encodeState) canView(roles [][]string) bool {
+	for _, set := range roles {
+		if !e.hasAnyRole(set) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *encodeState) hasAnyRole(roles []string) bool {
+	for _, role := range roles {
+		switch {
+		case role == RolePublic:
+			return true
+		case e.viewer == nil:
+			continue
+		case role == RoleAuthenticated, e.viewer.HasRole(role):
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeViewer turns typed nil pointers, such as a missing principal, into
+// an anonymous viewer
+func normalizeViewer(viewer Viewer) Viewer {
+	if viewer == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(viewer)
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+
+	return viewer
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testViewer has the roles it lists
+type testViewer []string
+
+func (v testViewer) HasRole(role string) bool {
+	for _, r := range v {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// pointerViewer stands in for a *Principal, which may be a typed nil
+type pointerViewer struct {
+	roles testViewer
+}
+
+func (v *pointerViewer) HasRole(role string) bool {
+	return v.roles.HasRole(role)
+}
+
+type viewAudit struct {
+	Editor string `json:"editor" api:"roles=owner"`
+}
+
+type viewCarrier struct {
+	Name      string `json:"name"`
+	Public    string `json:"public" api:"roles=public"`
+	Internal  string `json:"internal" api:"roles=authenticated"`
+	Billing   string `json:"billing" api:"roles=admin,billing"`
+	viewAudit `api:"roles=admin"`
+}
+
+func TestToApiDataFor(t *testing.T) {
+	carrier := viewCarrier{
+		Name:      "Acme",
+		Public:    "p",
+		Internal:  "i",
+		Billing:   "b",
+		viewAudit: viewAudit{Editor: "e"},
+	}
+
+	var nilPointer *pointerViewer
+
+	tests := []struct {
+		name   string
+		viewer Viewer
+		want   []string
+	}{
+		{"anonymous", nil, []string{"name", "public"}},
+		{"typed nil is anonymous", nilPointer, []string{"name", "public"}},
+		{"authenticated", testViewer{}, []string{"internal", "name", "public"}},
+		{"one of the listed roles", testViewer{"billing"}, []string{"billing", "internal", "name", "public"}},
+		{"embedded needs every role set", testViewer{"admin"}, []string{"billing", "internal", "name", "public"}},
+		{"embedded with every role set", &pointerViewer{testViewer{"admin", "owner"}}, []string{"billing", "editor", "internal", "name", "public"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rendered := ToApiDataFor(carrier, test.viewer).(map[string]interface{})
+			if keys := sortedKeys(rendered); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("got fields %v, want %v", keys, test.want)
+			}
+		})
+	}
+
+	if rendered := ToApiData(carrier).(map[string]interface{}); len(rendered) != 2 {
+		t.Errorf("ToApiData rendered %v, want the anonymous fields", sortedKeys(rendered))
+	}
+}
@@ -0,0 +1,303 @@
+package data_store
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Scheduled backups are named with their UTC time, so they sort by age
+const (
+	backupFilePrefix     = "store-"
+	backupFileExtension  = ".db"
+	backupFileTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Backup writes a consistent copy of the store to w. It reads within a single
+// transaction, so writes may continue while it runs. It returns the number
+// of bytes written.
+func (d *DocumentDao) Backup(w io.Writer) (int64, error) {
+	var written int64
+
+	err := d.store.View(func(tx *bolt.Tx) error {
+		var err error
+		written, err = tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return written, err
+	}
+
+	return written, nil
+}
+
+// BackupToFile writes a backup to path. The file only appears at path once
+// the backup is complete.
+func (d *DocumentDao) BackupToFile(path string) error {
+	temporaryPath := path + ".tmp"
+
+	file, err := os.OpenFile(temporaryPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.Backup(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		return err
+	}
+
+	return os.Rename(temporaryPath, path)
+}
+
+// BackupHandler serves a backup of the store as a download
+func (d *DocumentDao) BackupHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("no"))
+			return
+		}
+
+		err := d.store.View(func(tx *bolt.Tx) error {
+			name := backupFilePrefix + time.Now().UTC().Format(backupFileTimeFormat) + backupFileExtension
+
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			w.Header().Set("Content-Length", strconv.FormatInt(tx.Size(), 10))
+
+			if r.Method == http.MethodHead {
+				return nil
+			}
+
+			_, err := tx.WriteTo(w)
+			return err
+		})
+		if err != nil {
+			// Headers have been sent, so all that can be done is to cut the
+			// response short
+			log.Printf("error writing backup: %v", err)
+		}
+	})
+}
+
+// Restore replaces the contents of the store with the snapshot at path,
+// which must be a backup of a DocumentDao store. The snapshot is checked
+// before anything is replaced, and it is copied in within a single
+// transaction, so readers see either the old or the restored contents.
+//
+// The change log is replaced by the snapshot's, along with whether it's
+// enabled and its retention. Change log sequences and record versions carry
+// on from the later of the store's and the snapshot's, so none are handed out
+// twice, and events between the snapshot's sequence and the store's can't be
+// read. Every watcher is closed, and consumers should re-read the collections
+// they follow and watch again from the new ChangeLogSequence.
+func (d *DocumentDao) Restore(path string) error {
+	snapshot, err := bolt.Open(path, 0400, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("opening snapshot: %w", err)
+	}
+	defer snapshot.Close()
+
+	// Whether the change log is enabled is restored along with it
+	var changeLog *ChangeLogRetention
+
+	err = snapshot.View(func(source *bolt.Tx) error {
+		// Check's errors must all be read for it to finish
+		var checkErr error
+		for err := range source.Check() {
+			if checkErr == nil {
+				checkErr = err
+			}
+		}
+		if checkErr != nil {
+			return fmt.Errorf("invalid snapshot: %w", checkErr)
+		}
+
+		if source.Bucket([]byte(DefaultCollection)) == nil {
+			return fmt.Errorf("invalid snapshot: no %q collection", DefaultCollection)
+		}
+
+		return d.store.Update(func(tx *bolt.Tx) error {
+			var names [][]byte
+			sequences := map[string]uint64{}
+			err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				names = append(names, append([]byte(nil), name...))
+				return bucketSequences(sequences, string(name), b)
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, name := range names {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+
+			err = source.ForEach(func(name []byte, b *bolt.Bucket) error {
+				restored, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBucket(restored, b, sequences, string(name))
+			})
+			if err != nil {
+				return err
+			}
+
+			changeLog, err = loadChangeLog(tx)
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.changeLog = changeLog
+	d.mutex.Unlock()
+
+	// Sequences watchers have read up to mean nothing in the restored log
+	d.cancelWatchers()
+
+	return nil
+}
+
+// bucketSequences records the sequences of b and its nested buckets by path
+func bucketSequences(sequences map[string]uint64, path string, b *bolt.Bucket) error {
+	sequences[path] = b.Sequence()
+
+	return b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return bucketSequences(sequences, path+"\x00"+string(k), b.Bucket(k))
+	})
+}
+
+// copyBucket copies the records and nested buckets of source. Sequences are
+// set to the later of the source's and the one in sequences for the path.
+func copyBucket(destination, source *bolt.Bucket, sequences map[string]uint64, path string) error {
+	err := source.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return destination.Put(k, v)
+		}
+
+		nested, err := destination.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+
+		return copyBucket(nested, source.Bucket(k), sequences, path+"\x00"+string(k))
+	})
+	if err != nil {
+		return err
+	}
+
+	sequence := source.Sequence()
+	if sequences[path] > sequence {
+		sequence = sequences[path]
+	}
+
+	return destination.SetSequence(sequence)
+}
+
+// StartScheduledBackups writes a backup to dir every interval, keeping the
+// newest keep backups, until the store is closed or the returned function is
+// called
+func (d *DocumentDao) StartScheduledBackups(dir string, interval time.Duration, keep int) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := d.scheduledBackup(dir, keep); err != nil {
+					log.Printf("error backing up store: %v", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+
+	d.mutex.Lock()
+	d.stopFuncs = append(d.stopFuncs, stop)
+	d.mutex.Unlock()
+
+	return stop
+}
+
+func (d *DocumentDao) scheduledBackup(dir string, keep int) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	name := backupFilePrefix + time.Now().UTC().Format(backupFileTimeFormat) + backupFileExtension
+	if err := d.BackupToFile(filepath.Join(dir, name)); err != nil {
+		return err
+	}
+
+	if keep <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, backupFilePrefix) && strings.HasSuffix(name, backupFileExtension) {
+			backups = append(backups, name)
+		}
+	}
+
+	sort.Strings(backups)
+
+	for len(backups) > keep {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package data_store
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type backupTestTruck struct {
+	Plate string `json:"plate"`
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	c := newTestCollection(t, "fleet/trucks", map[string]any{
+		"t1": backupTestTruck{Plate: "ABC"},
+	})
+	if err := c.CreateIndex(IndexDefinition{Name: "plate", Fields: []string{"plate"}, Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := c.dao.BackupToFile(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.SetRecord("t2", backupTestTruck{Plate: "DEF"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteRecord("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.dao.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	var trucks []backupTestTruck
+	if err := c.GetRecords("", &trucks); err != nil {
+		t.Fatal(err)
+	}
+	if len(trucks) != 1 || trucks[0].Plate != "ABC" {
+		t.Errorf("got %+v after the restore, want only ABC", trucks)
+	}
+
+	// Indexes are restored with the records
+	var truck backupTestTruck
+	if err := c.LookupOne("plate", []any{"ABC"}, &truck); err != nil {
+		t.Errorf("lookup after the restore: %v", err)
+	}
+	if err := c.LookupOne("plate", []any{"DEF"}, &truck); !errors.Is(err, ErrNotFound) {
+		t.Errorf("lookup of a record written after the backup: got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestRestoreRejectsInvalidSnapshots(t *testing.T) {
+	d := newTestDao(t)
+	if err := d.SetRecord("a", backupTestTruck{Plate: "ABC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+
+	garbage := filepath.Join(dir, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bbolt database, but not one of a DocumentDao
+	foreign := filepath.Join(dir, "foreign.db")
+	db, err := bolt.Open(foreign, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("other"))
+		return err
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, snapshot := range []string{garbage, foreign, filepath.Join(dir, "missing.db")} {
+		if err := d.Restore(snapshot); err == nil {
+			t.Errorf("restored %s", filepath.Base(snapshot))
+		}
+	}
+
+	// Nothing was replaced
+	var truck backupTestTruck
+	if err := d.GetRecord("a", &truck); err != nil {
+		t.Errorf("record lost after a failed restore: %v", err)
+	}
+}
+
+func TestBackupHandler(t *testing.T) {
+	d := newTestDao(t)
+	if err := d.SetRecord("a", backupTestTruck{Plate: "ABC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	d.BackupHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/backup", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d", recorder.Code)
+	}
+	if recorder.Header().Get("Content-Disposition") == "" {
+		t.Error("no Content-Disposition header")
+	}
+
+	// The download is a snapshot that can be restored
+	snapshot := filepath.Join(t.TempDir(), "download.db")
+	if err := os.WriteFile(snapshot, recorder.Body.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteRecord("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	var truck backupTestTruck
+	if err := d.GetRecord("a", &truck); err != nil {
+		t.Errorf("get after restoring the download: %v", err)
+	}
+
+	recorder = httptest.NewRecorder()
+	d.BackupHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/backup", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got status %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestRestoreClosesWatchers(t *testing.T) {
+	d := newTestDao(t)
+	if err := d.EnableChangeLog(ChangeLogRetention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetRecord("a", map[string]int{"v": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := d.BackupToFile(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	backedUp, err := d.ChangeLogSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"b", "c"} {
+		if err := d.SetRecord(key, map[string]int{"v": 2}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest, err := d.ChangeLogSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, _, err := d.Watch("", latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := nextEvent(t, events); ok {
+		t.Error("watcher still open after the restore")
+	}
+
+	// Sequences don't go back to the snapshot's, and the events lost with
+	// the restore can't be read
+	restored, err := d.ChangeLogSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != latest {
+		t.Errorf("got sequence %d after the restore, want %d", restored, latest)
+	}
+	if _, _, err := d.Watch("", backedUp); !errors.Is(err, ErrChangeLogTruncated) {
+		t.Errorf("got %v watching from the snapshot's sequence, want %v", err, ErrChangeLogTruncated)
+	}
+
+	var records []map[string]int
+	if err := d.GetRecords("", &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("got %d records after the restore, want 1", len(records))
+	}
+}
+
+func TestRestoreKeepsSequencesIncreasing(t *testing.T) {
+	c := newTestCollection(t, "trucks", nil)
+	if err := c.dao.EnableChangeLog(ChangeLogRetention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Insert("t1", backupTestTruck{Plate: "ABC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := c.dao.BackupToFile(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	var latestVersion uint64
+	for _, key := range []string{"t2", "t3"} {
+		version, err := c.Insert(key, backupTestTruck{Plate: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		latestVersion = version
+	}
+	latest, err := c.dao.ChangeLogSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.dao.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel, err := c.Watch("", latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	// t2 is gone with the restore, so it can be inserted again
+	version, err := c.Insert("t2", backupTestTruck{Plate: "DEF"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version <= latestVersion {
+		t.Errorf("got version %d after the restore, want more than %d", version, latestVersion)
+	}
+
+	event, ok := nextEvent(t, events)
+	if !ok || event.Key != "t2" || event.Sequence != latest+1 {
+		t.Errorf("got %+v, want the insert of t2 with sequence %d", event, latest+1)
+	}
+}
@@ -0,0 +1,403 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The change log is a system bucket of change events keyed by their
+// big-endian sequence number. Sequences come from the bucket's sequence, so
+// they are consecutive for committed writes. The log is enabled while the
+// bucket exists, and its retention is kept in it under a key that sorts
+// before every event.
+const changeLogBucket = "_changelog"
+
+var changeLogRetentionKey = []byte{0x00}
+
+// How many events a watcher reads from the change log per transaction
+const watchBatchSize = 100
+
+const (
+	ChangePut    = "put"
+	ChangeDelete = "delete"
+	// The collection was dropped with its records. The event has no key.
+	ChangeDrop = "drop"
+)
+
+var (
+	ErrChangeLogDisabled = errors.New("change log is not enabled")
+	// Returned when the events after a sequence are no longer retained
+	ErrChangeLogTruncated = errors.New("change log truncated")
+)
+
+type ChangeEvent struct {
+	Sequence   uint64          `json:"sequence"`
+	Collection string          `json:"collection"`
+	Key        string          `json:"key"`
+	Op         string          `json:"op"`
+	Version    uint64          `json:"version,omitempty"`
+	Record     json.RawMessage `json:"record,omitempty"`
+	Time       time.Time       `json:"time"`
+}
+
+// ChangeLogRetention limits the change log. Zero values are unlimited.
+type ChangeLogRetention struct {
+	MaxEntries int
+	MaxAge     time.Duration
+}
+
+// EnableChangeLog records every subsequent put and delete in the change log.
+// The log and its retention are kept across restarts, so it stays enabled
+// when the store is opened again. Calling it again changes the retention.
+func (d *DocumentDao) EnableChangeLog(retention ChangeLogRetention) error {
+	jsonBytes, err := json.Marshal(retention)
+	if err != nil {
+		return err
+	}
+
+	err = d.store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(changeLogBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put(changeLogRetentionKey, jsonBytes)
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.changeLog = &retention
+	d.mutex.Unlock()
+
+	return nil
+}
+
+// loadChangeLog returns the stored retention of the change log, or nil if it
+// isn't enabled
+func loadChangeLog(tx *bolt.Tx) (*ChangeLogRetention, error) {
+	b := tx.Bucket([]byte(changeLogBucket))
+	if b == nil {
+		return nil, nil
+	}
+
+	retention := &ChangeLogRetention{}
+	if jsonBytes := b.Get(changeLogRetentionKey); jsonBytes != nil {
+		if err := json.Unmarshal(jsonBytes, retention); err != nil {
+			return nil, fmt.Errorf("change log retention: %w", err)
+		}
+	}
+
+	return retention, nil
+}
+
+func (d *DocumentDao) changeLogRetention() *ChangeLogRetention {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.changeLog
+}
+
+// ChangeLogSequence returns the sequence of the latest change event
+func (d *DocumentDao) ChangeLogSequence() (uint64, error) {
+	var sequence uint64
+
+	err := d.store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(changeLogBucket))
+		if b == nil {
+			return ErrChangeLogDisabled
+		}
+
+		sequence = b.Sequence()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return sequence, nil
+}
+
+// logChange appends an event for a write to the change log, if it is enabled
+func (c *CollectionDao) logChange(tx *bolt.Tx, op string, key, value []byte, version uint64) error {
+	retention := c.dao.changeLogRetention()
+	if retention == nil {
+		return nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte(changeLogBucket))
+	if err != nil {
+		return err
+	}
+
+	sequence, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	event := ChangeEvent{
+		Sequence:   sequence,
+		Collection: c.name,
+		Key:        string(key),
+		Op:         op,
+		Version:    version,
+		Record:     value,
+		Time:       time.Now().UTC(),
+	}
+
+	jsonBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Put(sequenceKey(sequence), jsonBytes); err != nil {
+		return err
+	}
+
+	if err := trimChangeLog(b, sequence, retention); err != nil {
+		return err
+	}
+
+	tx.OnCommit(c.dao.notifyWatchers)
+
+	return nil
+}
+
+// trimChangeLog deletes the oldest events that are beyond the retention
+// limits
+func trimChangeLog(b *bolt.Bucket, sequence uint64, retention *ChangeLogRetention) error {
+	if retention.MaxEntries <= 0 && retention.MaxAge <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-retention.MaxAge)
+	cursor := b.Cursor()
+
+	for k, v := cursor.Seek(sequenceKey(1)); k != nil; k, v = cursor.Next() {
+		expired := retention.MaxEntries > 0 && sequence-binary.BigEndian.Uint64(k) >= uint64(retention.MaxEntries)
+
+		if !expired && retention.MaxAge > 0 {
+			var event ChangeEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			expired = event.Time.Before(cutoff)
+		}
+
+		if !expired {
+			break
+		}
+
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readChanges returns up to limit events following the given sequence
+func (d *DocumentDao) readChanges(after uint64, limit int) ([]ChangeEvent, error) {
+	var events []ChangeEvent
+
+	err := d.store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(changeLogBucket))
+		if b == nil {
+			return ErrChangeLogDisabled
+		}
+
+		cursor := b.Cursor()
+		k, v := cursor.Seek(sequenceKey(after + 1))
+
+		// Events that have been written but trimmed can't be read
+		if k == nil && b.Sequence() > after {
+			return fmt.Errorf("events after %d: %w", after, ErrChangeLogTruncated)
+		}
+		if k != nil && binary.BigEndian.Uint64(k) != after+1 {
+			return fmt.Errorf("events after %d: %w", after, ErrChangeLogTruncated)
+		}
+
+		for ; k != nil && len(events) < limit; k, v = cursor.Next() {
+			var event ChangeEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func sequenceKey(sequence uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+
+	return key
+}
+
+type watcher struct {
+	collection string
+	prefix     []byte
+	events     chan ChangeEvent
+	// Signalled, without blocking, after writes are committed
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Watch delivers, in order, the events of puts and deletes of records with
+// keys starting with prefix that follow the after sequence. Pass the
+// sequence of the last event seen to resume, or the current
+// ChangeLogSequence to receive only new events. The channel is closed when
+// cancel is called, when the store is closed, after the drop event of the
+// collection, when a backup is restored, or if the watcher falls so far
+// behind that events it hasn't read are trimmed from the log.
+func (c *CollectionDao) Watch(prefix string, after uint64) (events <-chan ChangeEvent, cancel func(), err error) {
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+	if c.dao.changeLogRetention() == nil {
+		return nil, nil, ErrChangeLogDisabled
+	}
+
+	// Fail early if the events can't be read
+	if _, err := c.dao.readChanges(after, 1); err != nil {
+		return nil, nil, err
+	}
+
+	w := &watcher{
+		collection: c.name,
+		prefix:     []byte(prefix),
+		events:     make(chan ChangeEvent),
+		notify:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	cancel = func() {
+		w.once.Do(func() {
+			close(w.done)
+
+			c.dao.mutex.Lock()
+			delete(c.dao.watchers, w)
+			c.dao.mutex.Unlock()
+		})
+	}
+
+	c.dao.mutex.Lock()
+	if c.dao.watchers == nil {
+		c.dao.watchers = map[*watcher]func(){}
+	}
+	c.dao.watchers[w] = cancel
+	c.dao.mutex.Unlock()
+
+	go c.dao.runWatcher(w, after)
+
+	return w.events, cancel, nil
+}
+
+func (d *DocumentDao) Watch(prefix string, after uint64) (<-chan ChangeEvent, func(), error) {
+	return d.defaultCollection.Watch(prefix, after)
+}
+
+func (d *DocumentDao) runWatcher(w *watcher, after uint64) {
+	defer close(w.events)
+
+	for {
+		events, err := d.readChanges(after, watchBatchSize)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				log.Printf("error reading change log: %v", err)
+			}
+			return
+		}
+
+		for _, event := range events {
+			after = event.Sequence
+
+			if event.Collection != w.collection {
+				continue
+			}
+
+			dropped := event.Op == ChangeDrop
+			if !dropped && !bytes.HasPrefix([]byte(event.Key), w.prefix) {
+				continue
+			}
+
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+
+			// Nothing more is logged for the collection until it's created
+			// again, which watchers resume from the drop to follow
+			if dropped {
+				d.cancelWatcher(w)
+				return
+			}
+		}
+
+		if len(events) == watchBatchSize {
+			continue
+		}
+
+		select {
+		case <-w.notify:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// cancelWatcher calls the cancel function of w, if it's still watching
+func (d *DocumentDao) cancelWatcher(w *watcher) {
+	d.mutex.Lock()
+	cancel := d.watchers[w]
+	d.mutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// cancelWatchers closes every watcher
+func (d *DocumentDao) cancelWatchers() {
+	d.mutex.Lock()
+	cancels := make([]func(), 0, len(d.watchers))
+	for _, cancel := range d.watchers {
+		cancels = append(cancels, cancel)
+	}
+	d.mutex.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+func (d *DocumentDao) notifyWatchers() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for w := range d.watchers {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package data_store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChangeLogDisabled(t *testing.T) {
+	c := newTestCollection(t, "trucks", nil)
+
+	if _, _, err := c.Watch("", 0); !errors.Is(err, ErrChangeLogDisabled) {
+		t.Errorf("watch: got %v, want %v", err, ErrChangeLogDisabled)
+	}
+	if _, err := c.dao.ChangeLogSequence(); !errors.Is(err, ErrChangeLogDisabled) {
+		t.Errorf("sequence: got %v, want %v", err, ErrChangeLogDisabled)
+	}
+}
+
+func TestWatchDeliversChangesInOrder(t *testing.T) {
+	c := newTestCollection(t, "trucks", nil)
+	if err := c.dao.EnableChangeLog(ChangeLogRetention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, cancel, err := c.Watch("t", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	// Writes to other keys and collections are filtered out
+	if err := c.SetRecord("t1", map[string]string{"plate": "ABC"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetRecord("x1", map[string]string{"plate": "XYZ"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.dao.SetRecord("t2", map[string]string{"plate": "DEF"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteRecord("t1"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		op       string
+		key      string
+		sequence uint64
+	}{
+		{ChangePut, "t1", 1},
+		{ChangeDelete, "t1", 4},
+	}
+	for _, w := range want {
+		event, ok := nextEvent(t, events)
+		if !ok {
+			t.Fatal("watcher closed")
+		}
+		if event.Op != w.op || event.Key != w.key || event.Sequence != w.sequence || event.Collection != "trucks" {
+			t.Errorf("got %+v, want %s of %s at %d", event, w.op, w.key, w.sequence)
+		}
+	}
+
+	cancel()
+	if _, ok := nextEvent(t, events); ok {
+		t.Error("watcher still open after cancel")
+	}
+}
+
+func TestWatchResumes(t *testing.T) {
+	c := newTestCollection(t, "trucks", nil)
+	if err := c.dao.EnableChangeLog(ChangeLogRetention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"t1", "t2", "t3"} {
+		if err := c.SetRecord(key, map[string]string{"plate": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, cancel, err := c.Watch("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	for _, key := range []string{"t2", "t3"} {
+		event, ok := nextEvent(t, events)
+		if !ok || event.Key != key {
+			t.Errorf("got %+v, want the put of %s", event, key)
+		}
+	}
+}
+
+func TestWatchTruncatedChangeLog(t *testing.T) {
+	c := newTestCollection(t, "trucks", nil)
+	if err := c.dao.EnableChangeLog(ChangeLogRetention{MaxEntries: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"t1", "t2", "t3", "t4"} {
+		if err := c.SetRecord(key, map[string]string{"plate": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := c.Watch("", 0); !errors.Is(err, ErrChangeLogTruncated) {
+		t.Errorf("got %v, want %v", err, ErrChangeLogTruncated)
+	}
+
+	// The retained events can still be read
+	events, cancel, err := c.Watch("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if event, ok := nextEvent(t, events); !ok || event.Key != "t3" {
+		t.Errorf("got %+v, want the put of t3", event)
+	}
+}
+
+func TestDropCollectionClosesWatchers(t *testing.T) {
+	d := newTestDao(t)
+	if err := d.EnableChangeLog(ChangeLogRetention{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"fleet", "fleet/trucks"} {
+		if _, err := d.CreateCollection(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Collection("fleet/trucks").SetRecord("t1", map[string]string{"plate": "ABC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	start, err := d.ChangeLogSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, _, err := d.Collection("fleet/trucks").Watch("t", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DropCollection("fleet"); err != nil {
+		t.Fatal(err)
+	}
+
+	event, ok := nextEvent(t, events)
+	if !ok || event.Op != ChangeDrop || event.Collection != "fleet/trucks" || event.Key != "" {
+		t.Fatalf("got %+v, want the drop of fleet/trucks", event)
+	}
+	if _, ok := nextEvent(t, events); ok {
+		t.Error("watcher still open after the drop")
+	}
+
+	// Both the collection and the nested one are logged as dropped
+	changes, err := d.readChanges(start, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dropped []string
+	for _, change := range changes {
+		if change.Op == ChangeDrop {
+			dropped = append(dropped, change.Collection)
+		}
+	}
+	if len(dropped) != 2 || dropped[0] != "fleet" || dropped[1] != "fleet/trucks" {
+		t.Errorf("got drops of %v, want fleet and fleet/trucks", dropped)
+	}
+}
+
+func TestChangeLogStaysEnabled(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := NewDocumentDao(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.EnableChangeLog(ChangeLogRetention{MaxEntries: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRecord("c1", map[string]string{"name": "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	d = newTestDaoIn(t, dir)
+
+	// Writes are still logged, and trimmed to the stored retention
+	for _, key := range []string{"c2", "c3", "c4"} {
+		if err := d.SetRecord(key, map[string]string{"name": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sequence, err := d.ChangeLogSequence()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sequence != 4 {
+		t.Errorf("got sequence %d, want 4", sequence)
+	}
+
+	if _, err := d.readChanges(0, 10); !errors.Is(err, ErrChangeLogTruncated) {
+		t.Errorf("got %v, want %v", err, ErrChangeLogTruncated)
+	}
+	changes, err := d.readChanges(2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Key != "c3" || changes[1].Key != "c4" {
+		t.Errorf("got %+v, want the changes to c3 and c4", changes)
+	}
+
+	events, cancel, err := d.Watch("", sequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	if err := d.SetRecord("c5", map[string]string{"name": "c5"}); err != nil {
+		t.Fatal(err)
+	}
+	if event, ok := nextEvent(t, events); !ok || event.Key != "c5" {
+		t.Errorf("got %+v, want the change to c5", event)
+	}
+}
@@ -0,0 +1,74 @@
+// Command reindex rebuilds the secondary indexes of a DocumentDao store.
+//
+//	reindex -dir /var/lib/app -collection carriers [-index name]
+//
+// Every index of the collection is rebuilt unless -index is given. The store
+// can't be open in another process.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/williamhaley/go/data_store"
+	bolt "go.etcd.io/bbolt"
+)
+
+func main() {
+	dir := flag.String("dir", "", "directory holding store.db")
+	collection := flag.String("collection", data_store.DefaultCollection, "collection to reindex")
+	index := flag.String("index", "", "index to rebuild; all of the collection's indexes if empty")
+	timeout := flag.Duration("timeout", 5*time.Second, "how long to wait for another process to close the store")
+	flag.Parse()
+
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*dir, *collection, *index, *timeout); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, collection, index string, timeout time.Duration) error {
+	dao, err := data_store.NewDocumentDaoWithOptions(dir, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", dir, err)
+	}
+
+	if err := reindex(dao.Collection(collection), index); err != nil {
+		dao.Close()
+		return err
+	}
+
+	return dao.Close()
+}
+
+func reindex(c *data_store.CollectionDao, index string) error {
+	names := []string{index}
+	if index == "" {
+		definitions, err := c.Indexes()
+		if err != nil {
+			return err
+		}
+
+		names = names[:0]
+		for _, definition := range definitions {
+			names = append(names, definition.Name)
+		}
+	}
+
+	for _, name := range names {
+		if err := c.RebuildIndex(name); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("rebuilt %s/%s\n", c.Name(), name)
+	}
+
+	return nil
+}
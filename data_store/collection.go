@@ -0,0 +1,383 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrCollectionNotFound = errors.New("collection not found")
+)
+
+// CollectionDao reads and writes the records of a single collection. Each
+// collection is its own bucket, and nested collections are buckets within
+// it, named with "/" separated paths such as "shipments/archived".
+type CollectionDao struct {
+	dao  *DocumentDao
+	name string
+	path [][]byte
+	// Set when name is not a valid collection name
+	err error
+}
+
+func (c *CollectionDao) Name() string {
+	return c.name
+}
+
+// bucket returns the collection's bucket, or an error if the collection
+// doesn't exist
+func (c *CollectionDao) bucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	b := tx.Bucket(c.path[0])
+	for _, name := range c.path[1:] {
+		if b == nil {
+			break
+		}
+		b = b.Bucket(name)
+	}
+	if b == nil {
+		return nil, fmt.Errorf("collection %q: %w", c.name, ErrCollectionNotFound)
+	}
+
+	return b, nil
+}
+
+// putRecord is the single path through which records are written. A zero
+// expiresAt means the record doesn't expire. It returns the record's new
+// version.
+func (c *CollectionDao) putRecord(tx *bolt.Tx, b *bolt.Bucket, key []byte, value []byte, expiresAt time.Time) (uint64, error) {
+	if b.Bucket(key) != nil {
+		return 0, fmt.Errorf("key %q is a nested collection", key)
+	}
+
+	if err := c.updateIndexes(tx, key, b.Get(key), value); err != nil {
+		return 0, err
+	}
+
+	if err := b.Put(key, value); err != nil {
+		return 0, err
+	}
+
+	version, err := c.updateMeta(tx, b, key, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, c.logChange(tx, ChangePut, key, value, version)
+}
+
+// deleteRecord is the single path through which records are deleted. When
+// cursor is set it is positioned on key and is used to delete the record.
+func (c *CollectionDao) deleteRecord(tx *bolt.Tx, b *bolt.Bucket, key []byte, cursor *bolt.Cursor) error {
+	if b.Bucket(key) != nil {
+		return fmt.Errorf("key %q is a nested collection", key)
+	}
+
+	if value := b.Get(key); value != nil {
+		if err := c.updateIndexes(tx, key, value, nil); err != nil {
+			return err
+		}
+
+		if err := c.logChange(tx, ChangeDelete, key, nil, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := c.deleteMeta(tx, key); err != nil {
+		return err
+	}
+
+	if cursor != nil {
+		return cursor.Delete()
+	}
+
+	return b.Delete(key)
+}
+
+func (c *CollectionDao) SetRecord(key string, record any) error {
+	err := c.dao.store.Update(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		jsonBytes, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.putRecord(tx, b, []byte(key), jsonBytes, time.Time{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *CollectionDao) SetRecordsBatch(recordsBatch []struct {
+	Key    string
+	Record any
+}) error {
+	err := c.dao.store.Batch(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, writeRequest := range recordsBatch {
+			jsonBytes, err := json.Marshal(writeRequest.Record)
+			if err != nil {
+				return err
+			}
+
+			if _, err := c.putRecord(tx, b, []byte(writeRequest.Key), jsonBytes, time.Time{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *CollectionDao) DeleteRecord(key string) error {
+	err := c.dao.store.Update(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return c.deleteRecord(tx, b, []byte(key), nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *CollectionDao) GetRecords(prefix string, destination any) error {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return fmt.Errorf("getRecords called with non-pointer destination")
+	}
+	if reflect.Indirect(reflect.ValueOf(destination)).Kind() != reflect.Slice {
+		return fmt.Errorf("getRecords called with non-slice pointer destination")
+	}
+
+	recordType := reflect.TypeOf(destination).Elem().Elem()
+	valueOfDestination := reflect.ValueOf(destination)
+	destinationElem := valueOfDestination.Elem()
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := b.Cursor()
+		live := c.liveness(tx)
+
+		prefix := []byte(prefix)
+		for k, value := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, value = cursor.Next() {
+			// Nested collections have nil values
+			if value == nil || !live(k) {
+				continue
+			}
+
+			var reader = bytes.NewBuffer(value)
+			var record = reflect.New(recordType).Interface()
+			if err := json.NewDecoder(reader).Decode(record); err != nil {
+				return err
+			}
+
+			destinationElem.Set(
+				reflect.Append(
+					destinationElem,
+					reflect.Indirect(reflect.ValueOf(record)).Convert(recordType),
+				),
+			)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *CollectionDao) GetRecord(key string, destination any) error {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return fmt.Errorf("non-pointer destination")
+	}
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+		value := b.Get([]byte(key))
+
+		// Nested collections have nil values
+		if value == nil || !c.liveness(tx)([]byte(key)) {
+			return ErrNotFound
+		}
+
+		var reader = bytes.NewBuffer(value)
+		if err := json.NewDecoder(reader).Decode(destination); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *CollectionDao) Count(prefix string) (int, error) {
+	count := 0
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := b.Cursor()
+		live := c.liveness(tx)
+
+		prefix := []byte(prefix)
+		for k, value := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, value = cursor.Next() {
+			if value != nil && live(k) {
+				count++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+type CollectionStats struct {
+	Name string
+	// Records directly in the collection
+	Records int
+	// Collections directly nested in the collection
+	Collections int
+	// Bytes used by the collection, including nested collections
+	BytesInUse int
+	// Bytes allocated for the collection, including nested collections
+	BytesAllocated int
+}
+
+func (c *CollectionDao) Stats() (*CollectionStats, error) {
+	stats := &CollectionStats{
+		Name: c.name,
+	}
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		err = b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				stats.Collections++
+			} else {
+				stats.Records++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		bucketStats := b.Stats()
+		stats.BytesInUse = bucketStats.BranchInuse + bucketStats.LeafInuse + bucketStats.InlineBucketInuse
+		stats.BytesAllocated = bucketStats.BranchAlloc + bucketStats.LeafAlloc
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// collectionPath splits a "/" separated collection name into bucket names
+func collectionPath(name string) ([][]byte, error) {
+	if name == "" {
+		return nil, fmt.Errorf("empty collection name")
+	}
+
+	var path [][]byte
+	for _, part := range strings.Split(name, "/") {
+		if part == "" {
+			return nil, fmt.Errorf("invalid collection name %q", name)
+		}
+		path = append(path, []byte(part))
+	}
+
+	return path, nil
+}
+
+// isSystemBucket reports whether name is used by the store itself rather than
+// holding a collection
+func isSystemBucket(name []byte) bool {
+	return bytes.HasPrefix(name, []byte("_"))
+}
+
+// dropSystemBuckets deletes the indexes and record metadata of the named
+// collection and of the collections nested in it
+func dropSystemBuckets(tx *bolt.Tx, name string) error {
+	for _, system := range []string{indexDefinitionsBucket, indexEntriesBucket, recordsMetaBucket, expiryBucket} {
+		b := tx.Bucket([]byte(system))
+		if b == nil {
+			continue
+		}
+
+		var names [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			if string(k) == name || strings.HasPrefix(string(k), name+"/") {
+				names = append(names, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range names {
+			if err := b.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
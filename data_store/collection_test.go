@@ -0,0 +1,53 @@
+package data_store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDropCollection(t *testing.T) {
+	d := newTestDao(t)
+
+	for _, name := range []string{"fleet", "fleet/trucks"} {
+		if _, err := d.CreateCollection(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Collection("fleet/trucks").SetRecord("t1", map[string]string{"plate": "ABC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DropCollection("fleet"); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]string
+	if err := d.Collection("fleet/trucks").GetRecord("t1", &record); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("got %v, want %v", err, ErrCollectionNotFound)
+	}
+	if err := d.DropCollection("fleet"); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("got %v dropping it again, want %v", err, ErrCollectionNotFound)
+	}
+}
+
+func TestDropCollectionRefusesReservedNames(t *testing.T) {
+	d := newTestDao(t)
+	if err := d.SetRecord("c1", map[string]string{"name": "Acme"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{DefaultCollection, "_changelog", ""} {
+		if err := d.DropCollection(name); err == nil {
+			t.Errorf("dropped %q", name)
+		}
+	}
+
+	// The record methods still work
+	var record map[string]string
+	if err := d.GetRecord("c1", &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["name"] != "Acme" {
+		t.Errorf("got %v, want the record kept", record)
+	}
+}
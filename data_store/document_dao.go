@@ -5,213 +5,295 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
-	"reflect"
+	"sort"
+	"sync"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+// DefaultCollection is the collection used by the DocumentDao record methods
+const DefaultCollection = "default"
+
 type DocumentDao struct {
-	store *bolt.DB
+	store             *bolt.DB
+	defaultCollection *CollectionDao
+
+	mutex sync.Mutex
+	// Stop background work such as the expiry sweeper
+	stopFuncs []func()
+	// Set when the change log is enabled
+	changeLog *ChangeLogRetention
+	// Cancel functions of active watchers
+	watchers map[*watcher]func()
 }
 
 func NewDocumentDao(persistenceDirectory string) (*DocumentDao, error) {
-	db, err := bolt.Open(path.Join(persistenceDirectory, "store.db"), 0666, nil)
+	return NewDocumentDaoWithOptions(persistenceDirectory, nil)
+}
+
+// NewDocumentDaoWithOptions is NewDocumentDao with options for opening the
+// bolt database, such as a Timeout for when another process has it open
+func NewDocumentDaoWithOptions(persistenceDirectory string, options *bolt.Options) (*DocumentDao, error) {
+	db, err := bolt.Open(path.Join(persistenceDirectory, "store.db"), 0666, options)
 	if err != nil {
 		return nil, err
 	}
 
 	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte("default"))
+		_, err := tx.CreateBucket([]byte(DefaultCollection))
 		if err != nil {
 			return err
 		}
 		return nil
 	})
 
-	return &DocumentDao{
-		store: db,
-	}, nil
-}
+	// The change log stays enabled once it has been
+	var changeLog *ChangeLogRetention
+	err = db.View(func(tx *bolt.Tx) error {
+		changeLog, err = loadChangeLog(tx)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-func (d *DocumentDao) SetRecord(key string, record any) error {
-	err := d.store.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("default"))
+	d := &DocumentDao{
+		store:     db,
+		changeLog: changeLog,
+	}
+	d.defaultCollection = d.Collection(DefaultCollection)
 
-		jsonBytes, err := json.Marshal(record)
-		if err != nil {
-			return err
-		}
+	return d, nil
+}
 
-		if err := b.Put([]byte(key), jsonBytes); err != nil {
-			return err
-		}
+// Collection returns a handle for the named collection. The collection must
+// have been created for reads and writes through the handle to succeed.
+func (d *DocumentDao) Collection(name string) *CollectionDao {
+	path, err := collectionPath(name)
 
-		return nil
-	})
-	if err != nil {
-		return err
+	return &CollectionDao{
+		dao:  d,
+		name: name,
+		path: path,
+		err:  err,
 	}
-
-	return nil
 }
 
-func (d *DocumentDao) SetRecordsBatch(recordsBatch []struct {
-	Key    string
-	Record any
-}) error {
-	err := d.store.Batch(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("default"))
+// CreateCollection creates the named collection. Parents of nested
+// collections are created as needed.
+func (d *DocumentDao) CreateCollection(name string) (*CollectionDao, error) {
+	c := d.Collection(name)
+	if c.err != nil {
+		return nil, c.err
+	}
+	if isSystemBucket(c.path[0]) {
+		return nil, fmt.Errorf("collection names may not start with \"_\"")
+	}
+
+	err := d.store.Update(func(tx *bolt.Tx) error {
+		create := tx.CreateBucket
+		createIfNotExists := tx.CreateBucketIfNotExists
 
-		for _, writeRequest := range recordsBatch {
-			jsonBytes, err := json.Marshal(writeRequest.Record)
+		for _, name := range c.path[:len(c.path)-1] {
+			b, err := createIfNotExists(name)
 			if err != nil {
 				return err
 			}
+			create, createIfNotExists = b.CreateBucket, b.CreateBucketIfNotExists
+		}
 
-			if err := b.Put([]byte(writeRequest.Key), jsonBytes); err != nil {
-				return err
-			}
+		if _, err := create(c.path[len(c.path)-1]); err != nil {
+			return fmt.Errorf("collection %q: %w", name, err)
 		}
 
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return c, nil
 }
 
-func (d *DocumentDao) DeleteRecord(key string) error {
-	err := d.store.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("default"))
+// DropCollection deletes the named collection, its records and any nested
+// collections. If the change log is enabled, a drop event is logged for each
+// of the collections, and watchers of them are closed after receiving it. The
+// default collection can't be dropped, since the record methods depend on it.
+func (d *DocumentDao) DropCollection(name string) error {
+	c := d.Collection(name)
+	if c.err != nil {
+		return c.err
+	}
+	if isSystemBucket(c.path[0]) {
+		return fmt.Errorf("collection names may not start with \"_\"")
+	}
+	if name == DefaultCollection {
+		return fmt.Errorf("the %q collection can't be dropped", DefaultCollection)
+	}
 
-		if err := b.Delete([]byte(key)); err != nil {
+	return d.store.Update(func(tx *bolt.Tx) error {
+		parent := tx.DeleteBucket
+		if len(c.path) > 1 {
+			b, err := d.Collection(path.Dir(name)).bucket(tx)
+			if err != nil {
+				return err
+			}
+			parent = b.DeleteBucket
+		}
+
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+		nested, err := nestedCollections(name, b)
+		if err != nil {
 			return err
 		}
 
-		return nil
-	})
-	if err != nil {
-		return err
-	}
+		if err := parent(c.path[len(c.path)-1]); err != nil {
+			return fmt.Errorf("collection %q: %w", name, err)
+		}
 
-	return nil
-}
+		for _, dropped := range append([]string{name}, nested...) {
+			if err := d.Collection(dropped).logChange(tx, ChangeDrop, nil, nil, 0); err != nil {
+				return err
+			}
+		}
 
-func (d *DocumentDao) GetRecords(prefix string, destination any) error {
-	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
-		return fmt.Errorf("getRecords called with non-pointer destination")
-	}
-	if reflect.Indirect(reflect.ValueOf(destination)).Kind() != reflect.Slice {
-		return fmt.Errorf("getRecords called with non-slice pointer destination")
-	}
+		return dropSystemBuckets(tx, name)
+	})
+}
 
-	recordType := reflect.TypeOf(destination).Elem().Elem()
-	valueOfDestination := reflect.ValueOf(destination)
-	destinationElem := valueOfDestination.Elem()
+// ListCollections returns the names of all collections, including nested
+// collections, sorted by name
+func (d *DocumentDao) ListCollections() ([]string, error) {
+	var names []string
 
 	err := d.store.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte("default")).Cursor()
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if isSystemBucket(name) {
+				return nil
+			}
 
-		prefix := []byte(prefix)
-		for k, value := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, value = c.Next() {
-			var reader = bytes.NewBuffer(value)
-			var record = reflect.New(recordType).Interface()
-			if err := json.NewDecoder(reader).Decode(record); err != nil {
+			nested, err := nestedCollections(string(name), b)
+			if err != nil {
 				return err
 			}
 
-			destinationElem.Set(
-				reflect.Append(
-					destinationElem,
-					reflect.Indirect(reflect.ValueOf(record)).Convert(recordType),
-				),
-			)
-		}
-
-		return nil
+			names = append(append(names, string(name)), nested...)
+			return nil
+		})
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
-}
+	sort.Strings(names)
 
-func (d *DocumentDao) GetRecord(key string, destination any) error {
-	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
-		return fmt.Errorf("non-pointer destination")
-	}
+	return names, nil
+}
 
-	err := d.store.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("default"))
-		value := b.Get([]byte(key))
+// nestedCollections returns the names of the collections nested, at any
+// depth, in the named collection b
+func nestedCollections(name string, b *bolt.Bucket) ([]string, error) {
+	var names []string
 
-		if value == nil {
-			return fmt.Errorf("not found")
+	err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
 		}
 
-		var reader = bytes.NewBuffer(value)
-		if err := json.NewDecoder(reader).Decode(&destination); err != nil {
+		nestedName := name + "/" + string(k)
+		nested, err := nestedCollections(nestedName, b.Bucket(k))
+		if err != nil {
 			return err
 		}
 
+		names = append(append(names, nestedName), nested...)
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return names, nil
 }
 
-func (d *DocumentDao) Count(prefix string) (int, error) {
-	count := 0
+func (d *DocumentDao) SetRecord(key string, record any) error {
+	return d.defaultCollection.SetRecord(key, record)
+}
 
-	err := d.store.View(func(tx *bolt.Tx) error {
-		c := tx.Bucket([]byte("default")).Cursor()
+func (d *DocumentDao) SetRecordsBatch(recordsBatch []struct {
+	Key    string
+	Record any
+}) error {
+	return d.defaultCollection.SetRecordsBatch(recordsBatch)
+}
 
-		prefix := []byte(prefix)
-		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
-			count++
-		}
+func (d *DocumentDao) DeleteRecord(key string) error {
+	return d.defaultCollection.DeleteRecord(key)
+}
 
-		return nil
-	})
-	if err != nil {
-		return 0, err
-	}
+func (d *DocumentDao) GetRecords(prefix string, destination any) error {
+	return d.defaultCollection.GetRecords(prefix, destination)
+}
 
-	return count, nil
+func (d *DocumentDao) GetRecord(key string, destination any) error {
+	return d.defaultCollection.GetRecord(key, destination)
+}
+
+func (d *DocumentDao) Count(prefix string) (int, error) {
+	return d.defaultCollection.Count(prefix)
 }
 
 type IteratorResult struct {
-	cursor *bolt.Cursor
-	Key    string
-	Record any
+	collection *CollectionDao
+	tx         *bolt.Tx
+	bucket     *bolt.Bucket
+	cursor     *bolt.Cursor
+	Key        string
+	Record     any
 }
 
 func (r *IteratorResult) Delete() error {
-	return r.cursor.Delete()
+	return r.collection.deleteRecord(r.tx, r.bucket, []byte(r.Key), r.cursor)
 }
 
 // Not defined as a method in order to use generics https://github.com/golang/go/issues/48793#issuecomment-1079910818
 func Iterate[T any](d *DocumentDao, prefix string, handler func(result *IteratorResult) error) error {
-	err := d.store.Update(func(tx *bolt.Tx) error {
-		cursor := tx.Bucket([]byte("default")).Cursor()
+	return IterateCollection[T](d.defaultCollection, prefix, handler)
+}
+
+func IterateCollection[T any](c *CollectionDao, prefix string, handler func(result *IteratorResult) error) error {
+	err := c.dao.store.Update(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := b.Cursor()
 
 		prefix := []byte(prefix)
+		live := c.liveness(tx)
+
 		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			// Nested collections have nil values
+			if value == nil || !live(key) {
+				continue
+			}
+
 			record := new(T)
 			if err := json.Unmarshal(value, &record); err != nil {
 				return err
 			}
 
 			iteratorResult := &IteratorResult{
-				cursor: cursor,
-				Key:    string(key),
-				Record: record,
+				collection: c,
+				tx:         tx,
+				bucket:     b,
+				cursor:     cursor,
+				Key:        string(key),
+				Record:     record,
 			}
 
 			if err := handler(iteratorResult); err != nil {
@@ -228,5 +310,17 @@ func Iterate[T any](d *DocumentDao, prefix string, handler func(result *Iterator
 }
 
 func (d *DocumentDao) Close() error {
+	d.mutex.Lock()
+	stopFuncs := d.stopFuncs
+	d.stopFuncs = nil
+	for _, cancel := range d.watchers {
+		stopFuncs = append(stopFuncs, cancel)
+	}
+	d.mutex.Unlock()
+
+	for _, stop := range stopFuncs {
+		stop()
+	}
+
 	return d.store.Close()
 }
@@ -0,0 +1,334 @@
+package data_store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Exports are newline-delimited JSON, one record per line, along with its
+// metadata. Imports read the same format, gzipped or not.
+
+type ImportConflict string
+
+const (
+	// Existing records are replaced
+	ImportUpsert ImportConflict = "upsert"
+	// Existing records are kept
+	ImportSkip ImportConflict = "skip"
+	// The import stops at the first existing record
+	ImportFail ImportConflict = "fail"
+)
+
+const (
+	defaultImportBatchSize = 1000
+	// How many errors an ImportReport lists. Failed counts all of them.
+	maxImportErrors = 100
+)
+
+type ExportOptions struct {
+	// Only export records with keys starting with Prefix
+	Prefix string
+	Gzip   bool
+}
+
+type ImportOptions struct {
+	// Defaults to ImportUpsert
+	Conflict ImportConflict
+	// How many records are written per transaction, defaults to 1000
+	BatchSize int
+}
+
+type ImportError struct {
+	Line    int    `json:"line"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	// Non-blank lines read, including malformed lines
+	Read    int `json:"read"`
+	Written int `json:"written"`
+	// Records skipped because they exist or have expired
+	Skipped int           `json:"skipped"`
+	Failed  int           `json:"failed"`
+	Errors  []ImportError `json:"errors,omitempty"`
+}
+
+func (r *ImportReport) fail(line int, key string, err error) {
+	r.Failed++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, ImportError{
+			Line:    line,
+			Key:     key,
+			Message: err.Error(),
+		})
+	}
+}
+
+type exportLine struct {
+	Key    string          `json:"key"`
+	Record json.RawMessage `json:"record"`
+	// Versions are informational, imported records are given new versions
+	Version   uint64     `json:"version,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Export writes the collection's records to w as newline-delimited JSON, in
+// key order, and returns how many were written. The records are read in a
+// single transaction, so the export is consistent while writes continue.
+func (c *CollectionDao) Export(w io.Writer, options ExportOptions) (int, error) {
+	var gzipWriter *gzip.Writer
+	if options.Gzip {
+		gzipWriter = gzip.NewWriter(w)
+		w = gzipWriter
+	}
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	count := 0
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		cursor := b.Cursor()
+		live := c.liveness(tx)
+
+		prefix := []byte(options.Prefix)
+		for k, value := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, value = cursor.Next() {
+			// Nested collections have nil values
+			if value == nil || !live(k) {
+				continue
+			}
+
+			meta, err := c.meta(tx, k)
+			if err != nil {
+				return err
+			}
+
+			line := exportLine{
+				Key:     string(k),
+				Record:  value,
+				Version: meta.Version,
+			}
+			if meta.ExpiresAt != 0 {
+				expiresAt := time.Unix(0, meta.ExpiresAt).UTC()
+				line.ExpiresAt = &expiresAt
+			}
+
+			if err := encoder.Encode(line); err != nil {
+				return err
+			}
+			count++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return count, err
+	}
+	if gzipWriter != nil {
+		if err := gzipWriter.Close(); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+func (d *DocumentDao) Export(w io.Writer, options ExportOptions) (int, error) {
+	return d.defaultCollection.Export(w, options)
+}
+
+// Import reads newline-delimited JSON written by Export, gzipped or not, and
+// writes the records in batches. Malformed lines and records that can't be
+// written are reported and skipped. With ImportFail, the import stops with
+// ErrConflict at the first existing record, after writing the batches
+// before it.
+func (c *CollectionDao) Import(r io.Reader, options ImportOptions) (*ImportReport, error) {
+	if options.Conflict == "" {
+		options.Conflict = ImportUpsert
+	}
+	if options.Conflict != ImportUpsert && options.Conflict != ImportSkip && options.Conflict != ImportFail {
+		return nil, fmt.Errorf("unknown import conflict mode %q", options.Conflict)
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultImportBatchSize
+	}
+
+	reader := bufio.NewReader(r)
+
+	// Gzip streams start with 0x1f 0x8b
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+
+		reader = bufio.NewReader(gzipReader)
+	}
+
+	report := &ImportReport{}
+	var batch []importRecord
+
+	for {
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return report, readErr
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			report.Read++
+
+			var line exportLine
+			if err := json.Unmarshal(data, &line); err != nil {
+				report.fail(report.Read, "", err)
+			} else if line.Key == "" || len(line.Record) == 0 {
+				report.fail(report.Read, line.Key, fmt.Errorf("line has no key or record"))
+			} else {
+				batch = append(batch, importRecord{
+					line:   report.Read,
+					record: line,
+				})
+			}
+		}
+
+		if len(batch) == options.BatchSize || (readErr == io.EOF && len(batch) > 0) {
+			if err := c.importBatch(batch, options.Conflict, report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+
+		if readErr == io.EOF {
+			return report, nil
+		}
+	}
+}
+
+func (d *DocumentDao) Import(r io.Reader, options ImportOptions) (*ImportReport, error) {
+	return d.defaultCollection.Import(r, options)
+}
+
+type importRecord struct {
+	line   int
+	record exportLine
+}
+
+// errImportRecord wraps the error of a single record so that the batch can
+// be retried without it
+type errImportRecord struct {
+	index int
+	err   error
+}
+
+func (e *errImportRecord) Error() string {
+	return e.err.Error()
+}
+
+// importBatch writes batch in one transaction. If a record fails, the
+// transaction is discarded and the records are written one at a time, so
+// the failure is reported against that record alone.
+func (c *CollectionDao) importBatch(batch []importRecord, conflict ImportConflict, report *ImportReport) error {
+	var written, skipped int
+
+	err := c.dao.store.Update(func(tx *bolt.Tx) error {
+		written, skipped = 0, 0
+
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		for index, item := range batch {
+			ok, err := c.importRecord(tx, b, item.record, conflict)
+			if err != nil {
+				return &errImportRecord{index: index, err: err}
+			}
+			if ok {
+				written++
+			} else {
+				skipped++
+			}
+		}
+
+		return nil
+	})
+
+	var recordErr *errImportRecord
+	if errors.As(err, &recordErr) {
+		if errors.Is(recordErr.err, ErrConflict) {
+			// Write the records before the conflict, then stop
+			if err := c.importBatch(batch[:recordErr.index], conflict, report); err != nil {
+				return err
+			}
+			return fmt.Errorf("line %d: %w", batch[recordErr.index].line, recordErr.err)
+		}
+
+		if len(batch) == 1 {
+			report.fail(batch[0].line, batch[0].record.Key, recordErr.err)
+			return nil
+		}
+
+		for index := range batch {
+			if err := c.importBatch(batch[index:index+1], conflict, report); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	report.Written += written
+	report.Skipped += skipped
+
+	return nil
+}
+
+// importRecord writes record unless the conflict mode says otherwise. It
+// reports whether the record was written.
+func (c *CollectionDao) importRecord(tx *bolt.Tx, b *bolt.Bucket, record exportLine, conflict ImportConflict) (bool, error) {
+	key := []byte(record.Key)
+
+	var expiresAt time.Time
+	if record.ExpiresAt != nil {
+		if !record.ExpiresAt.After(time.Now()) {
+			return false, nil
+		}
+		expiresAt = *record.ExpiresAt
+	}
+
+	if conflict != ImportUpsert {
+		if value, _, err := c.versionedRecord(tx, b, key); err != nil {
+			return false, err
+		} else if value != nil {
+			if conflict == ImportFail {
+				return false, fmt.Errorf("record %q already exists: %w", record.Key, ErrConflict)
+			}
+			return false, nil
+		}
+	}
+
+	if _, err := c.putRecord(tx, b, key, record.Record, expiresAt); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,62 @@
+package data_store
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestDao opens a store in a temporary directory that is closed when the
+// test ends
+func newTestDao(t *testing.T) *DocumentDao {
+	t.Helper()
+
+	return newTestDaoIn(t, t.TempDir())
+}
+
+// newTestDaoIn opens the store in dir, which is closed when the test ends
+func newTestDaoIn(t *testing.T, dir string) *DocumentDao {
+	t.Helper()
+
+	d, err := NewDocumentDao(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.Close()
+	})
+
+	return d
+}
+
+// newTestCollection creates the named collection in a new store and writes
+// records to it by key
+func newTestCollection(t *testing.T, name string, records map[string]any) *CollectionDao {
+	t.Helper()
+
+	c, err := newTestDao(t).CreateCollection(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, record := range records {
+		if err := c.SetRecord(key, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return c
+}
+
+// nextEvent returns the next event from events, or false if the channel is
+// closed
+func nextEvent(t *testing.T, events <-chan ChangeEvent) (ChangeEvent, bool) {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		return event, ok
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a change event")
+		return ChangeEvent{}, false
+	}
+}
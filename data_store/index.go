@@ -0,0 +1,496 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Index definitions and entries are kept in system buckets, with a nested
+// bucket per collection. Entries are kept in a further nested bucket per
+// index, keyed by the encoded field values. Keys of non-unique indexes end
+// with the record key so that records with equal values have distinct keys.
+// The value of every entry is the record key.
+const (
+	indexDefinitionsBucket = "_index_definitions"
+	indexEntriesBucket     = "_index_entries"
+)
+
+var (
+	ErrIndexNotFound   = errors.New("index not found")
+	ErrUniqueViolation = errors.New("unique index violation")
+)
+
+type IndexDefinition struct {
+	Name string
+	// "." separated paths of the JSON fields to index, e.g. "address.city".
+	// Records missing any of the fields are not indexed.
+	Fields []string
+	Unique bool
+}
+
+// IndexRange selects index entries from From, inclusive, to To, exclusive.
+// Either may hold values for only the first fields of a compound index, and
+// a nil bound is unbounded.
+type IndexRange struct {
+	From    []any
+	To      []any
+	Limit   int
+	Reverse bool
+}
+
+// CreateIndex declares an index on the collection and indexes the existing
+// records. It fails if a unique index would have duplicate entries.
+func (c *CollectionDao) CreateIndex(definition IndexDefinition) error {
+	if definition.Name == "" {
+		return fmt.Errorf("index name is required")
+	}
+	if len(definition.Fields) == 0 {
+		return fmt.Errorf("index %q has no fields", definition.Name)
+	}
+
+	return c.dao.store.Update(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		definitions, err := createNestedBucket(tx, indexDefinitionsBucket, c.name)
+		if err != nil {
+			return err
+		}
+		if definitions.Get([]byte(definition.Name)) != nil {
+			return fmt.Errorf("index %q already exists", definition.Name)
+		}
+
+		jsonBytes, err := json.Marshal(definition)
+		if err != nil {
+			return err
+		}
+		if err := definitions.Put([]byte(definition.Name), jsonBytes); err != nil {
+			return err
+		}
+
+		return c.buildIndex(tx, b, definition)
+	})
+}
+
+func (c *CollectionDao) DropIndex(name string) error {
+	return c.dao.store.Update(func(tx *bolt.Tx) error {
+		definitions := nestedBucket(tx, indexDefinitionsBucket, c.name)
+		if definitions == nil || definitions.Get([]byte(name)) == nil {
+			return fmt.Errorf("index %q: %w", name, ErrIndexNotFound)
+		}
+		if err := definitions.Delete([]byte(name)); err != nil {
+			return err
+		}
+
+		if entries := nestedBucket(tx, indexEntriesBucket, c.name); entries != nil {
+			if err := entries.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Indexes returns the collection's index definitions, sorted by name
+func (c *CollectionDao) Indexes() ([]IndexDefinition, error) {
+	var definitions []IndexDefinition
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		var err error
+		definitions, err = c.indexes(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return definitions, nil
+}
+
+// RebuildIndex discards the entries of the named index and indexes every
+// record again
+func (c *CollectionDao) RebuildIndex(name string) error {
+	return c.dao.store.Update(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		definition, err := c.index(tx, name)
+		if err != nil {
+			return err
+		}
+
+		if entries := nestedBucket(tx, indexEntriesBucket, c.name); entries != nil {
+			if err := entries.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		return c.buildIndex(tx, b, *definition)
+	})
+}
+
+// Lookup reads the records whose indexed fields equal values, in index order.
+// values may hold only the first fields of a compound index.
+func (c *CollectionDao) Lookup(name string, values []any, destination any) error {
+	prefix, err := indexValues(values)
+	if err != nil {
+		return err
+	}
+
+	return c.readIndex(name, prefix, prefixEnd(prefix), 0, false, destination)
+}
+
+// LookupOne reads the single record whose indexed fields equal values, or
+// returns ErrNotFound. It is meant for unique indexes.
+func (c *CollectionDao) LookupOne(name string, values []any, destination any) error {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return fmt.Errorf("non-pointer destination")
+	}
+
+	prefix, err := indexValues(values)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	err = c.dao.store.View(func(tx *bolt.Tx) error {
+		return c.scanIndex(tx, name, prefix, prefixEnd(prefix), false, func(entryKey, key, value []byte) (bool, error) {
+			found = true
+			return false, json.Unmarshal(value, destination)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Range reads the records selected by r, in index order
+func (c *CollectionDao) Range(name string, r IndexRange, destination any) error {
+	var start, end []byte
+	var err error
+
+	if r.From != nil {
+		if start, err = indexValues(r.From); err != nil {
+			return err
+		}
+	}
+	if r.To != nil {
+		if end, err = indexValues(r.To); err != nil {
+			return err
+		}
+	}
+
+	return c.readIndex(name, start, end, r.Limit, r.Reverse, destination)
+}
+
+func (c *CollectionDao) readIndex(name string, start, end []byte, limit int, reverse bool, destination any) error {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return fmt.Errorf("non-pointer destination")
+	}
+	if reflect.Indirect(reflect.ValueOf(destination)).Kind() != reflect.Slice {
+		return fmt.Errorf("non-slice pointer destination")
+	}
+
+	recordType := reflect.TypeOf(destination).Elem().Elem()
+	destinationElem := reflect.ValueOf(destination).Elem()
+
+	return c.dao.store.View(func(tx *bolt.Tx) error {
+		count := 0
+
+		return c.scanIndex(tx, name, start, end, reverse, func(entryKey, key, value []byte) (bool, error) {
+			if err := appendRecord(destinationElem, recordType, value); err != nil {
+				return false, err
+			}
+
+			count++
+			return limit <= 0 || count < limit, nil
+		})
+	})
+}
+
+// appendRecord decodes value as recordType and appends it to destinationElem
+func appendRecord(destinationElem reflect.Value, recordType reflect.Type, value []byte) error {
+	var record = reflect.New(recordType).Interface()
+	if err := json.Unmarshal(value, record); err != nil {
+		return err
+	}
+
+	destinationElem.Set(
+		reflect.Append(
+			destinationElem,
+			reflect.Indirect(reflect.ValueOf(record)).Convert(recordType),
+		),
+	)
+
+	return nil
+}
+
+// scanIndex calls handler with the entry key and the record key and value of
+// each index entry in [start, end), until handler returns false. Nil bounds
+// are unbounded.
+func (c *CollectionDao) scanIndex(tx *bolt.Tx, name string, start, end []byte, reverse bool, handler func(entryKey, key, value []byte) (bool, error)) error {
+	b, err := c.bucket(tx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.index(tx, name); err != nil {
+		return err
+	}
+
+	entries := nestedBucket(tx, indexEntriesBucket, c.name, name)
+	if entries == nil {
+		return nil
+	}
+
+	cursor := entries.Cursor()
+
+	var k, recordKey []byte
+	inRange := func(k []byte) bool {
+		return k != nil && (start == nil || bytes.Compare(k, start) >= 0) && (end == nil || bytes.Compare(k, end) < 0)
+	}
+	advance := cursor.Next
+
+	if reverse {
+		advance = cursor.Prev
+		if end == nil {
+			k, recordKey = cursor.Last()
+		} else if k, recordKey = cursor.Seek(end); k == nil {
+			k, recordKey = cursor.Last()
+		} else {
+			k, recordKey = cursor.Prev()
+		}
+	} else if start == nil {
+		k, recordKey = cursor.First()
+	} else {
+		k, recordKey = cursor.Seek(start)
+	}
+
+	live := c.liveness(tx)
+
+	for ; inRange(k); k, recordKey = advance() {
+		value := b.Get(recordKey)
+		if value == nil {
+			return fmt.Errorf("index %q has an entry for missing record %q", name, recordKey)
+		}
+		if !live(recordKey) {
+			continue
+		}
+
+		more, err := handler(k, recordKey, value)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (c *CollectionDao) index(tx *bolt.Tx, name string) (*IndexDefinition, error) {
+	definitions := nestedBucket(tx, indexDefinitionsBucket, c.name)
+	if definitions == nil {
+		return nil, fmt.Errorf("index %q: %w", name, ErrIndexNotFound)
+	}
+
+	value := definitions.Get([]byte(name))
+	if value == nil {
+		return nil, fmt.Errorf("index %q: %w", name, ErrIndexNotFound)
+	}
+
+	definition := &IndexDefinition{}
+	if err := json.Unmarshal(value, definition); err != nil {
+		return nil, err
+	}
+
+	return definition, nil
+}
+
+func (c *CollectionDao) indexes(tx *bolt.Tx) ([]IndexDefinition, error) {
+	var definitions []IndexDefinition
+
+	b := nestedBucket(tx, indexDefinitionsBucket, c.name)
+	if b == nil {
+		return definitions, nil
+	}
+
+	err := b.ForEach(func(k, v []byte) error {
+		var definition IndexDefinition
+		if err := json.Unmarshal(v, &definition); err != nil {
+			return err
+		}
+
+		definitions = append(definitions, definition)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+
+	return definitions, nil
+}
+
+// buildIndex indexes every record in b
+func (c *CollectionDao) buildIndex(tx *bolt.Tx, b *bolt.Bucket, definition IndexDefinition) error {
+	entries, err := createNestedBucket(tx, indexEntriesBucket, c.name, definition.Name)
+	if err != nil {
+		return err
+	}
+
+	live := c.liveness(tx)
+
+	return b.ForEach(func(key, value []byte) error {
+		// Nested collections have nil values, and expired records are not
+		// indexed
+		if value == nil || !live(key) {
+			return nil
+		}
+
+		record, err := decodeRecord(value)
+		if err != nil {
+			return fmt.Errorf("record %q: %w", key, err)
+		}
+
+		return putIndexEntry(entries, definition, record, key, live)
+	})
+}
+
+// updateIndexes replaces the index entries for the record at key. oldValue
+// is nil for new records and newValue is nil for deleted records.
+func (c *CollectionDao) updateIndexes(tx *bolt.Tx, key, oldValue, newValue []byte) error {
+	definitions, err := c.indexes(tx)
+	if err != nil || len(definitions) == 0 {
+		return err
+	}
+
+	var oldRecord, newRecord any
+	if oldValue != nil {
+		if oldRecord, err = decodeRecord(oldValue); err != nil {
+			return err
+		}
+	}
+	if newValue != nil {
+		if newRecord, err = decodeRecord(newValue); err != nil {
+			return err
+		}
+	}
+
+	live := c.liveness(tx)
+
+	for _, definition := range definitions {
+		entries, err := createNestedBucket(tx, indexEntriesBucket, c.name, definition.Name)
+		if err != nil {
+			return err
+		}
+
+		if oldValue != nil {
+			entryKey, ok, err := indexEntryKey(definition, oldRecord, key)
+			if err != nil {
+				return err
+			}
+			// The entry of an expired record may since have been taken by
+			// another record with the same unique values
+			if ok && bytes.Equal(entries.Get(entryKey), key) {
+				if err := entries.Delete(entryKey); err != nil {
+					return err
+				}
+			}
+		}
+
+		if newValue != nil {
+			if err := putIndexEntry(entries, definition, newRecord, key, live); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// putIndexEntry adds the entry for record. Unique entries of expired records
+// are replaced.
+func putIndexEntry(entries *bolt.Bucket, definition IndexDefinition, record any, key []byte, live func(key []byte) bool) error {
+	entryKey, ok, err := indexEntryKey(definition, record, key)
+	if err != nil || !ok {
+		return err
+	}
+
+	if existing := entries.Get(entryKey); existing != nil && !bytes.Equal(existing, key) && live(existing) {
+		return fmt.Errorf("index %q: record %q has the same values as record %q: %w", definition.Name, key, existing, ErrUniqueViolation)
+	}
+
+	return entries.Put(entryKey, key)
+}
+
+// indexEntryKey returns the key of the index entry for record, or false if
+// the record is missing an indexed field
+func indexEntryKey(definition IndexDefinition, record any, key []byte) ([]byte, bool, error) {
+	var entryKey []byte
+
+	for _, path := range definition.Fields {
+		value, ok := recordField(record, path)
+		if !ok {
+			return nil, false, nil
+		}
+
+		var err error
+		if entryKey, err = encodeIndexValue(entryKey, value); err != nil {
+			return nil, false, fmt.Errorf("index %q: field %q: %w", definition.Name, path, err)
+		}
+	}
+
+	if !definition.Unique {
+		entryKey = append(entryKey, key...)
+	}
+
+	return entryKey, true, nil
+}
+
+func nestedBucket(tx *bolt.Tx, names ...string) *bolt.Bucket {
+	b := tx.Bucket([]byte(names[0]))
+	for _, name := range names[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(name))
+	}
+
+	return b
+}
+
+func createNestedBucket(tx *bolt.Tx, names ...string) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(names[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names[1:] {
+		if b, err = b.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
@@ -0,0 +1,203 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Index values are encoded so that comparing encoded keys byte by byte gives
+// the same order as comparing the values. Each value starts with a tag that
+// orders values of different JSON types, and every encoding is
+// self-delimiting so that compound keys can be compared field by field.
+const (
+	indexTagNull   byte = 0x01
+	indexTagFalse  byte = 0x02
+	indexTagTrue   byte = 0x03
+	indexTagNumber byte = 0x04
+	indexTagString byte = 0x05
+	// Arrays and objects are compared by their JSON encoding
+	indexTagJSON byte = 0x06
+)
+
+// encodeIndexValue appends the encoding of value, which must be a value
+// produced by decoding JSON with UseNumber
+func encodeIndexValue(buffer []byte, value any) ([]byte, error) {
+	switch value := value.(type) {
+	case nil:
+		return append(buffer, indexTagNull), nil
+	case bool:
+		if value {
+			return append(buffer, indexTagTrue), nil
+		}
+		return append(buffer, indexTagFalse), nil
+	case json.Number:
+		return encodeIndexJSONNumber(append(buffer, indexTagNumber), value)
+	case float64:
+		return encodeIndexNumber(append(buffer, indexTagNumber), value, 0), nil
+	case string:
+		return encodeIndexString(append(buffer, indexTagString), value), nil
+	case []any, map[string]any:
+		// Maps are encoded with sorted keys, so equal values encode equally
+		jsonBytes, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return encodeIndexString(append(buffer, indexTagJSON), string(jsonBytes)), nil
+	default:
+		return nil, fmt.Errorf("unsupported index value type %T", value)
+	}
+}
+
+// encodeIndexJSONNumber encodes number exactly when it's an integer, since
+// float64 only represents every integer up to 2^53. Beyond that, integers
+// that round to the same float64 are ordered by their distance from it, which
+// is exact for integers of up to 35 digits.
+func encodeIndexJSONNumber(buffer []byte, number json.Number) ([]byte, error) {
+	rounded, err := number.Float64()
+	if err != nil {
+		return nil, err
+	}
+	if math.Abs(rounded) < 1<<53 {
+		return encodeIndexNumber(buffer, rounded, 0), nil
+	}
+
+	exact, ok := new(big.Rat).SetString(number.String())
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", number)
+	}
+
+	// Every float64 this large is an integer. Div rounds down, as the
+	// denominator is positive, so fractions order with the integer below.
+	nearest, _ := new(big.Float).SetFloat64(rounded).Int(nil)
+	offset := new(big.Int).Div(exact.Num(), exact.Denom())
+	offset.Sub(offset, nearest)
+
+	switch {
+	case offset.IsInt64():
+		return encodeIndexNumber(buffer, rounded, offset.Int64()), nil
+	case offset.Sign() < 0:
+		return encodeIndexNumber(buffer, rounded, math.MinInt64), nil
+	default:
+		return encodeIndexNumber(buffer, rounded, math.MaxInt64), nil
+	}
+}
+
+// encodeIndexNumber flips the sign bit of positive numbers and all bits of
+// negative numbers, so that the big-endian bytes sort in numeric order. The
+// offset of an integer from number follows, with its sign bit flipped, to
+// order integers that round to the same float64.
+func encodeIndexNumber(buffer []byte, number float64, offset int64) []byte {
+	if number == 0 {
+		// Treat -0 and 0 as the same value
+		number = 0
+	}
+
+	bits := math.Float64bits(number)
+	if number < 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	var encoded [16]byte
+	binary.BigEndian.PutUint64(encoded[:8], bits)
+	binary.BigEndian.PutUint64(encoded[8:], uint64(offset)^(1<<63))
+
+	return append(buffer, encoded[:]...)
+}
+
+// encodeIndexString escapes 0x00 as 0x00 0xFF and terminates the string with
+// 0x00 0x01, so that a string sorts before any longer string it prefixes
+func encodeIndexString(buffer []byte, value string) []byte {
+	for index := 0; index < len(value); index++ {
+		buffer = append(buffer, value[index])
+		if value[index] == 0x00 {
+			buffer = append(buffer, 0xFF)
+		}
+	}
+
+	return append(buffer, 0x00, 0x01)
+}
+
+// indexValues encodes go values given to the lookup methods the same way as
+// values read from records
+func indexValues(values []any) ([]byte, error) {
+	var buffer []byte
+
+	for _, value := range values {
+		normalized, err := normalizeIndexValue(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if buffer, err = encodeIndexValue(buffer, normalized); err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer, nil
+}
+
+// normalizeIndexValue converts value to the form it has in a stored record
+func normalizeIndexValue(value any) (any, error) {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized any
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&normalized); err != nil {
+		return nil, err
+	}
+
+	return normalized, nil
+}
+
+// decodeRecord decodes a stored record for reading indexed fields
+func decodeRecord(value []byte) (any, error) {
+	var record any
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// recordField returns the value at the "." separated path in record
+func recordField(record any, path string) (any, bool) {
+	value := record
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix, or
+// nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for index := len(end) - 1; index >= 0; index-- {
+		if end[index] < 0xFF {
+			end[index]++
+			return end[:index+1]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestIndexNumberOrder(t *testing.T) {
+	// In ascending order
+	numbers := []json.Number{
+		"-1e300",
+		"-9007199254740993",
+		"-9007199254740992",
+		"-1.5",
+		"0",
+		"0.5",
+		"1",
+		"9007199254740992",
+		"9007199254740993",
+		"9007199254740994",
+		"9223372036854775807",
+		"18446744073709551615",
+		"18446744073709551616",
+		"1e300",
+	}
+
+	var previous []byte
+	for _, number := range numbers {
+		encoded, err := encodeIndexValue(nil, number)
+		if err != nil {
+			t.Fatalf("%s: %v", number, err)
+		}
+		if previous != nil && bytes.Compare(previous, encoded) >= 0 {
+			t.Errorf("%s doesn't sort after the number before it", number)
+		}
+		previous = encoded
+	}
+}
+
+func TestIndexNumberEquality(t *testing.T) {
+	for _, equal := range [][]json.Number{
+		{"3", "3.0", "3e0", "0.3e1"},
+		{"0", "-0", "0.0"},
+		{"100000000000000000000", "1e20"},
+		{"9007199254740993", "9007199254740993.0"},
+	} {
+		want, err := encodeIndexValue(nil, equal[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, number := range equal[1:] {
+			got, err := encodeIndexValue(nil, number)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s and %s encode differently", number, equal[0])
+			}
+		}
+	}
+}
+
+func TestIndexLargeIntegers(t *testing.T) {
+	type account struct {
+		Number int64 `json:"number"`
+	}
+
+	c := newTestCollection(t, "accounts", map[string]any{
+		"a": account{Number: 1 << 53},
+		"b": account{Number: 1<<53 + 1},
+		"c": account{Number: 1<<53 + 2},
+	})
+	if err := c.CreateIndex(IndexDefinition{Name: "number", Fields: []string{"number"}, Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	var found account
+	if err := c.LookupOne("number", []any{int64(1<<53 + 1)}, &found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Number != 1<<53+1 {
+		t.Errorf("got %d, want %d", found.Number, int64(1<<53+1))
+	}
+
+	var accounts []account
+	if err := c.Range("number", IndexRange{From: []any{int64(1<<53 + 1)}}, &accounts); err != nil {
+		t.Fatal(err)
+	}
+	if want := []account{{1<<53 + 1}, {1<<53 + 2}}; !reflect.DeepEqual(accounts, want) {
+		t.Errorf("got %v, want %v", accounts, want)
+	}
+}
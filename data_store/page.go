@@ -0,0 +1,155 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type PageOptions struct {
+	Prefix string
+	// Return records after this key
+	After string
+	// Return records before this key. Takes precedence over After.
+	Before string
+	Limit  int
+}
+
+type PageInfo struct {
+	FirstKey string
+	LastKey  string
+	HasNext  bool
+	HasPrev  bool
+}
+
+// GetRecordsPage reads up to options.Limit records with options.Prefix, in key
+// order, starting after options.After or ending before options.Before
+func (d *DocumentDao) GetRecordsPage(options PageOptions, destination any) (*PageInfo, error) {
+	return d.defaultCollection.GetRecordsPage(options, destination)
+}
+
+func (c *CollectionDao) GetRecordsPage(options PageOptions, destination any) (*PageInfo, error) {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("getRecordsPage called with non-pointer destination")
+	}
+	if reflect.Indirect(reflect.ValueOf(destination)).Kind() != reflect.Slice {
+		return nil, fmt.Errorf("getRecordsPage called with non-slice pointer destination")
+	}
+	if options.Limit <= 0 {
+		return nil, fmt.Errorf("getRecordsPage called with non-positive limit")
+	}
+
+	recordType := reflect.TypeOf(destination).Elem().Elem()
+	destinationElem := reflect.ValueOf(destination).Elem()
+
+	info := &PageInfo{}
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := b.Cursor()
+		prefix := []byte(options.Prefix)
+
+		inPrefix := func(k []byte) bool {
+			return k != nil && bytes.HasPrefix(k, prefix)
+		}
+
+		// Nested collections have nil values and are skipped, as are expired
+		// records
+		live := c.liveness(tx)
+		skip := func(k, v []byte) bool {
+			return k != nil && (v == nil || !live(k))
+		}
+
+		next := func() ([]byte, []byte) {
+			k, v := cursor.Next()
+			for skip(k, v) {
+				k, v = cursor.Next()
+			}
+			return k, v
+		}
+		prev := func() ([]byte, []byte) {
+			k, v := cursor.Prev()
+			for skip(k, v) {
+				k, v = cursor.Prev()
+			}
+			return k, v
+		}
+		seek := func(seek []byte) ([]byte, []byte) {
+			k, v := cursor.Seek(seek)
+			if skip(k, v) {
+				return next()
+			}
+			return k, v
+		}
+
+		var keys, values [][]byte
+
+		if options.Before != "" {
+			k, v := cursor.Seek([]byte(options.Before))
+			if k == nil {
+				k, v = cursor.Last()
+				if skip(k, v) {
+					k, v = prev()
+				}
+			} else {
+				k, v = prev()
+			}
+
+			for ; inPrefix(k) && len(keys) < options.Limit; k, v = prev() {
+				keys = append([][]byte{k}, keys...)
+				values = append([][]byte{v}, values...)
+			}
+
+			info.HasPrev = inPrefix(k)
+			info.HasNext = true
+		} else {
+			k, v := seek(prefix)
+			if options.After != "" {
+				k, v = seek([]byte(options.After))
+				if k != nil && string(k) == options.After {
+					k, v = next()
+				}
+				info.HasPrev = true
+			}
+
+			for ; inPrefix(k) && len(keys) < options.Limit; k, v = next() {
+				keys = append(keys, k)
+				values = append(values, v)
+			}
+
+			info.HasNext = inPrefix(k)
+		}
+
+		for index, value := range values {
+			var record = reflect.New(recordType).Interface()
+			if err := json.Unmarshal(value, record); err != nil {
+				return err
+			}
+
+			destinationElem.Set(
+				reflect.Append(
+					destinationElem,
+					reflect.Indirect(reflect.ValueOf(record)).Convert(recordType),
+				),
+			)
+
+			if index == 0 {
+				info.FirstKey = string(keys[index])
+			}
+			info.LastKey = string(keys[index])
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
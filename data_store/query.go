@@ -0,0 +1,680 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type Operator string
+
+const (
+	OpEq  Operator = "="
+	OpGt  Operator = ">"
+	OpGte Operator = ">="
+	OpLt  Operator = "<"
+	OpLte Operator = "<="
+	// The field equals one of the values in a slice
+	OpIn Operator = "in"
+	// The field is a string containing the value, or an array with an element
+	// equal to the value
+	OpContains Operator = "contains"
+)
+
+var ErrInvalidQueryCursor = errors.New("invalid query cursor")
+
+// Query selects records of a collection. Fields are "." separated paths of
+// JSON fields, as in index definitions. Every predicate requires the field to
+// be present, and range predicates only match values of the same JSON type.
+type Query struct {
+	collection *CollectionDao
+	prefix     string
+	predicates []*predicate
+	orderBy    string
+	descending bool
+	limit      int
+	offset     int
+	cursor     []byte
+	err        error
+}
+
+type predicate struct {
+	field    string
+	operator Operator
+	value    any
+	// Encoded value, or encoded values for OpIn
+	encoded [][]byte
+}
+
+// QueryPlan describes how a query reads records
+type QueryPlan struct {
+	// Index used to find records, empty for a full scan of the collection
+	Index string
+	// Index fields whose values are fixed by equality predicates
+	IndexEquality []string
+	// Index field whose values are narrowed by range predicates
+	IndexRange string
+	// Predicates checked against every record that is read
+	Filters []string
+	// How results are ordered: "key", "index" or "memory"
+	Sort string
+}
+
+func (p *QueryPlan) String() string {
+	var b strings.Builder
+
+	if p.Index == "" {
+		b.WriteString("full scan")
+	} else {
+		fmt.Fprintf(&b, "index scan on %s", p.Index)
+		if len(p.IndexEquality) > 0 {
+			fmt.Fprintf(&b, " equality=%s", strings.Join(p.IndexEquality, ","))
+		}
+		if p.IndexRange != "" {
+			fmt.Fprintf(&b, " range=%s", p.IndexRange)
+		}
+	}
+
+	if len(p.Filters) > 0 {
+		fmt.Fprintf(&b, ", filter %s", strings.Join(p.Filters, " and "))
+	}
+	fmt.Fprintf(&b, ", sort by %s", p.Sort)
+
+	return b.String()
+}
+
+func (c *CollectionDao) Query() *Query {
+	return &Query{
+		collection: c,
+	}
+}
+
+// Prefix limits the query to records with keys starting with prefix
+func (q *Query) Prefix(prefix string) *Query {
+	q.prefix = prefix
+	return q
+}
+
+func (q *Query) Where(field string, operator Operator, value any) *Query {
+	p := &predicate{
+		field:    field,
+		operator: operator,
+		value:    value,
+	}
+
+	switch operator {
+	case OpEq, OpGt, OpGte, OpLt, OpLte, OpContains:
+		encoded, err := indexValues([]any{value})
+		if err != nil {
+			q.err = fmt.Errorf("%s %s: %w", field, operator, err)
+			return q
+		}
+		p.encoded = [][]byte{encoded}
+	case OpIn:
+		values, err := normalizeIndexValue(value)
+		if err != nil {
+			q.err = fmt.Errorf("%s %s: %w", field, operator, err)
+			return q
+		}
+		list, ok := values.([]any)
+		if !ok {
+			q.err = fmt.Errorf("%s %s: value must be a slice", field, operator)
+			return q
+		}
+		for _, value := range list {
+			encoded, err := encodeIndexValue(nil, value)
+			if err != nil {
+				q.err = fmt.Errorf("%s %s: %w", field, operator, err)
+				return q
+			}
+			p.encoded = append(p.encoded, encoded)
+		}
+	default:
+		q.err = fmt.Errorf("unknown operator %q", operator)
+		return q
+	}
+
+	q.predicates = append(q.predicates, p)
+	return q
+}
+
+// OrderBy orders the results by field. Records without the field are
+// excluded. Unordered queries return records in key order, or in index order
+// when an index is used.
+func (q *Query) OrderBy(field string, descending bool) *Query {
+	q.orderBy = field
+	q.descending = descending
+	return q
+}
+
+func (q *Query) Limit(limit int) *Query {
+	q.limit = limit
+	return q
+}
+
+// Offset skips the first results. It applies to the first page only and is
+// ignored when resuming with After.
+func (q *Query) Offset(offset int) *Query {
+	q.offset = offset
+	return q
+}
+
+// After resumes the query after the last result of the page that returned
+// cursor. Cursors only resume queries that are read the same way, and are
+// rejected with ErrInvalidQueryCursor once a different plan is picked, for
+// example after an index is created.
+func (q *Query) After(cursor string) *Query {
+	if cursor == "" {
+		q.cursor = nil
+		return q
+	}
+
+	position, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(position) == 0 {
+		q.err = ErrInvalidQueryCursor
+		return q
+	}
+
+	q.cursor = position
+	return q
+}
+
+// Find reads the selected records into destination, a pointer to a slice. If
+// the query has a limit and more records follow, it returns a cursor for
+// resuming the query with After.
+func (q *Query) Find(destination any) (string, error) {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return "", fmt.Errorf("find called with non-pointer destination")
+	}
+	if reflect.Indirect(reflect.ValueOf(destination)).Kind() != reflect.Slice {
+		return "", fmt.Errorf("find called with non-slice pointer destination")
+	}
+
+	recordType := reflect.TypeOf(destination).Elem().Elem()
+	destinationElem := reflect.ValueOf(destination).Elem()
+
+	var tag, next []byte
+	more := false
+	skipped, count := 0, 0
+
+	offset := q.offset
+	if q.cursor != nil {
+		offset = 0
+	}
+
+	tag, err := q.execute(func(position, value []byte) (bool, error) {
+		if skipped < offset {
+			skipped++
+			return true, nil
+		}
+
+		if q.limit > 0 && count == q.limit {
+			// A record follows the last result
+			more = true
+			return false, nil
+		}
+
+		if err := appendRecord(destinationElem, recordType, value); err != nil {
+			return false, err
+		}
+
+		count++
+		next = position
+		return true, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if !more {
+		return "", nil
+	}
+
+	return base64.RawURLEncoding.EncodeToString(append(tag, next...)), nil
+}
+
+// Count returns the number of records selected, ignoring limit and offset
+func (q *Query) Count() (int, error) {
+	count := 0
+
+	_, err := q.execute(func(position, value []byte) (bool, error) {
+		count++
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Explain returns the plan that Find and Count would use
+func (q *Query) Explain() (*QueryPlan, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	var plan *queryPlan
+	err := q.collection.dao.store.View(func(tx *bolt.Tx) error {
+		var err error
+		plan, err = q.plan(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return plan.describe(q), nil
+}
+
+type queryPlan struct {
+	index *IndexDefinition
+	// Number of leading index fields fixed by equality predicates
+	equality int
+	// Set when the index field after the equality fields has range predicates
+	rangeField string
+	start      []byte
+	end        []byte
+	// Set when the index scan returns records in the requested order
+	ordered bool
+}
+
+func (p *queryPlan) describe(q *Query) *QueryPlan {
+	plan := &QueryPlan{
+		Sort: "key",
+	}
+
+	if p.index != nil {
+		plan.Sort = "index"
+		plan.Index = p.index.Name
+		plan.IndexEquality = p.index.Fields[:p.equality]
+		plan.IndexRange = p.rangeField
+	}
+
+	for _, predicate := range q.predicates {
+		plan.Filters = append(plan.Filters, fmt.Sprintf("%s %s %v", predicate.field, predicate.operator, predicate.value))
+	}
+
+	if q.orderBy != "" && !p.ordered {
+		plan.Sort = "memory"
+	}
+
+	return plan
+}
+
+// plan picks the index that fixes the most leading fields with equality
+// predicates. Indexes are sparse, so an index is only used when every one of
+// its fields has a predicate or is the ordering field.
+func (q *Query) plan(tx *bolt.Tx) (*queryPlan, error) {
+	definitions, err := q.collection.indexes(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	constrained := map[string]bool{}
+	equal := map[string]*predicate{}
+	ranged := map[string][]*predicate{}
+	for _, p := range q.predicates {
+		constrained[p.field] = true
+		switch p.operator {
+		case OpEq:
+			equal[p.field] = p
+		case OpGt, OpGte, OpLt, OpLte:
+			ranged[p.field] = append(ranged[p.field], p)
+		}
+	}
+
+	best := &queryPlan{}
+	bestScore := 0
+
+	for index := range definitions {
+		definition := &definitions[index]
+
+		usable := true
+		for _, field := range definition.Fields {
+			if !constrained[field] && field != q.orderBy {
+				usable = false
+			}
+		}
+		if !usable {
+			continue
+		}
+
+		candidate := &queryPlan{
+			index: definition,
+		}
+		for _, field := range definition.Fields {
+			if equal[field] == nil {
+				break
+			}
+			candidate.equality++
+		}
+		if candidate.equality < len(definition.Fields) && len(ranged[definition.Fields[candidate.equality]]) > 0 {
+			candidate.rangeField = definition.Fields[candidate.equality]
+		}
+
+		if q.orderBy != "" {
+			for _, field := range definition.Fields[:candidate.equality] {
+				if field == q.orderBy {
+					candidate.ordered = true
+				}
+			}
+			if candidate.equality < len(definition.Fields) && definition.Fields[candidate.equality] == q.orderBy {
+				candidate.ordered = true
+			}
+		}
+
+		score := candidate.equality * 4
+		if candidate.rangeField != "" {
+			score += 2
+		}
+		if candidate.ordered {
+			score++
+		}
+
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+
+	if best.index == nil {
+		return best, nil
+	}
+
+	var prefix []byte
+	for _, field := range best.index.Fields[:best.equality] {
+		prefix = append(prefix, equal[field].encoded[0]...)
+	}
+	best.start, best.end = prefix, prefixEnd(prefix)
+
+	if best.rangeField != "" {
+		for _, p := range ranged[best.rangeField] {
+			bound := append(append([]byte(nil), prefix...), p.encoded[0]...)
+			tag := p.encoded[0][0]
+
+			switch p.operator {
+			case OpGt:
+				best.start = prefixEnd(bound)
+			case OpGte:
+				best.start = bound
+			case OpLt:
+				best.end = bound
+			case OpLte:
+				best.end = prefixEnd(bound)
+			}
+
+			// Range predicates only match values of the same type
+			if p.operator == OpLt || p.operator == OpLte {
+				if typeStart := append(append([]byte(nil), prefix...), tag); bytes.Compare(best.start, typeStart) < 0 {
+					best.start = typeStart
+				}
+			} else if typeEnd := append(append([]byte(nil), prefix...), tag+1); best.end == nil || bytes.Compare(best.end, typeEnd) > 0 {
+				best.end = typeEnd
+			}
+		}
+	}
+
+	return best, nil
+}
+
+// execute calls handler with the position and value of each selected record
+// after the cursor, in order, until handler returns false. The position
+// orders the records and, after the returned tag identifying the plan, is
+// what a cursor holds.
+func (q *Query) execute(handler func(position, value []byte) (bool, error)) ([]byte, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	var tag []byte
+	err := q.collection.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := q.collection.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		plan, err := q.plan(tx)
+		if err != nil {
+			return err
+		}
+
+		sorted := q.orderBy != "" && !plan.ordered
+
+		tag = plan.cursorTag(q, sorted)
+		cursor := q.cursor
+		if cursor != nil {
+			if !bytes.HasPrefix(cursor, tag) || len(cursor) == len(tag) {
+				return ErrInvalidQueryCursor
+			}
+			cursor = cursor[len(tag):]
+		}
+
+		// Records are sorted in memory unless the scan returns them in order
+		if sorted {
+			return q.executeSorted(tx, b, plan, cursor, handler)
+		}
+
+		descending := q.orderBy != "" && q.descending
+
+		return q.scan(tx, b, plan, descending, cursor, func(position, key, value []byte, record any) (bool, error) {
+			return handler(position, value)
+		})
+	})
+
+	return tag, err
+}
+
+// Cursor tags start with the kind of position that follows
+const (
+	keyCursor    = 'k'
+	indexCursor  = 'i'
+	sortedCursor = 's'
+)
+
+// cursorTag identifies how the plan orders records: by key, by the entries
+// of a named index, or in memory by a field
+func (p *queryPlan) cursorTag(q *Query, sorted bool) []byte {
+	switch {
+	case sorted:
+		return append(append([]byte{sortedCursor}, q.orderBy...), 0x00)
+	case p.index != nil:
+		return append(append([]byte{indexCursor}, p.index.Name...), 0x00)
+	}
+	return []byte{keyCursor}
+}
+
+// scan calls handler for each record read by the plan that matches the
+// query's predicates, after cursor
+func (q *Query) scan(tx *bolt.Tx, b *bolt.Bucket, plan *queryPlan, descending bool, cursor []byte, handler func(position, key, value []byte, record any) (bool, error)) error {
+	prefix := []byte(q.prefix)
+
+	visit := func(position, key, value []byte) (bool, error) {
+		if !bytes.HasPrefix(key, prefix) {
+			return true, nil
+		}
+
+		record, err := decodeRecord(value)
+		if err != nil {
+			return false, fmt.Errorf("record %q: %w", key, err)
+		}
+
+		if !q.matches(record) {
+			return true, nil
+		}
+
+		return handler(position, key, value, record)
+	}
+
+	if plan.index != nil {
+		start, end := plan.start, plan.end
+		if cursor != nil {
+			if descending {
+				if end == nil || bytes.Compare(cursor, end) < 0 {
+					end = cursor
+				}
+			} else if after := append(append([]byte(nil), cursor...), 0x00); bytes.Compare(after, start) > 0 {
+				start = after
+			}
+		}
+
+		return q.collection.scanIndex(tx, plan.index.Name, start, end, descending, visit)
+	}
+
+	live := q.collection.liveness(tx)
+	c := b.Cursor()
+	k, v := c.Seek(prefix)
+	if cursor != nil && bytes.Compare(cursor, prefix) >= 0 {
+		if k, v = c.Seek(cursor); k != nil && bytes.Equal(k, cursor) {
+			k, v = c.Next()
+		}
+	}
+
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		// Nested collections have nil values
+		if v == nil || !live(k) {
+			continue
+		}
+
+		more, err := visit(k, k, v)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (q *Query) executeSorted(tx *bolt.Tx, b *bolt.Bucket, plan *queryPlan, cursor []byte, handler func(position, value []byte) (bool, error)) error {
+	type sortedRecord struct {
+		position []byte
+		value    []byte
+	}
+
+	var records []sortedRecord
+
+	err := q.scan(tx, b, plan, false, nil, func(position, key, value []byte, record any) (bool, error) {
+		field, ok := recordField(record, q.orderBy)
+		if !ok {
+			return true, nil
+		}
+
+		sortKey, err := encodeIndexValue(nil, field)
+		if err != nil {
+			return false, fmt.Errorf("record %q: field %q: %w", key, q.orderBy, err)
+		}
+
+		// Sort keys are self-delimiting, so appending the record key breaks
+		// ties without changing the order
+		records = append(records, sortedRecord{
+			position: append(sortKey, key...),
+			value:    value,
+		})
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if q.descending {
+			return bytes.Compare(records[i].position, records[j].position) > 0
+		}
+		return bytes.Compare(records[i].position, records[j].position) < 0
+	})
+
+	for _, record := range records {
+		if cursor != nil {
+			comparison := bytes.Compare(record.position, cursor)
+			if (!q.descending && comparison <= 0) || (q.descending && comparison >= 0) {
+				continue
+			}
+		}
+
+		more, err := handler(record.position, record.value)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (q *Query) matches(record any) bool {
+	for _, p := range q.predicates {
+		if !p.matches(record) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *predicate) matches(record any) bool {
+	field, ok := recordField(record, p.field)
+	if !ok {
+		return false
+	}
+
+	if p.operator == OpContains {
+		switch field := field.(type) {
+		case string:
+			value, ok := p.value.(string)
+			return ok && strings.Contains(field, value)
+		case []any:
+			for _, element := range field {
+				if encoded, err := encodeIndexValue(nil, element); err == nil && bytes.Equal(encoded, p.encoded[0]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	encoded, err := encodeIndexValue(nil, field)
+	if err != nil {
+		return false
+	}
+
+	if p.operator == OpIn {
+		for _, value := range p.encoded {
+			if bytes.Equal(encoded, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Range predicates only match values of the same type
+	if p.operator != OpEq && encoded[0] != p.encoded[0][0] {
+		return false
+	}
+
+	comparison := bytes.Compare(encoded, p.encoded[0])
+
+	switch p.operator {
+	case OpEq:
+		return comparison == 0
+	case OpGt:
+		return comparison > 0
+	case OpGte:
+		return comparison >= 0
+	case OpLt:
+		return comparison < 0
+	case OpLte:
+		return comparison <= 0
+	}
+
+	return false
+}
@@ -0,0 +1,323 @@
+package data_store
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type queryTestCarrier struct {
+	Name  string   `json:"name"`
+	State string   `json:"state"`
+	Fleet int      `json:"fleet"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// newQueryTestCollection returns a collection of carriers keyed c1 to c6
+func newQueryTestCollection(t *testing.T) *CollectionDao {
+	t.Helper()
+
+	return newTestCollection(t, "carriers", map[string]any{
+		"c1": queryTestCarrier{Name: "Acme", State: "IL", Fleet: 12, Tags: []string{"hazmat"}},
+		"c2": queryTestCarrier{Name: "Bolt", State: "WI", Fleet: 3},
+		"c3": queryTestCarrier{Name: "Crest", State: "IL", Fleet: 40, Tags: []string{"hazmat", "interstate"}},
+		"c4": queryTestCarrier{Name: "Delta", State: "IN", Fleet: 7, Tags: []string{"interstate"}},
+		"c5": queryTestCarrier{Name: "Echo", State: "IL", Fleet: 3},
+		"c6": queryTestCarrier{Name: "Fern", State: "WI", Fleet: 25},
+	})
+}
+
+func carrierNames(carriers []queryTestCarrier) []string {
+	names := []string{}
+	for _, carrier := range carriers {
+		names = append(names, carrier.Name)
+	}
+	return names
+}
+
+func TestQueryOperators(t *testing.T) {
+	tests := []struct {
+		name     string
+		field    string
+		operator Operator
+		value    any
+		want     []string
+	}{
+		{"eq string", "state", OpEq, "IL", []string{"Acme", "Crest", "Echo"}},
+		{"eq number", "fleet", OpEq, 3, []string{"Bolt", "Echo"}},
+		{"gt", "fleet", OpGt, 12, []string{"Crest", "Fern"}},
+		{"gte", "fleet", OpGte, 12, []string{"Acme", "Crest", "Fern"}},
+		{"lt", "fleet", OpLt, 7, []string{"Bolt", "Echo"}},
+		{"lte", "fleet", OpLte, 7, []string{"Bolt", "Delta", "Echo"}},
+		{"range on other type", "fleet", OpGt, "0", []string{}},
+		{"in", "state", OpIn, []string{"IN", "WI"}, []string{"Bolt", "Delta", "Fern"}},
+		{"contains substring", "name", OpContains, "e", []string{"Acme", "Crest", "Delta", "Fern"}},
+		{"contains element", "tags", OpContains, "hazmat", []string{"Acme", "Crest"}},
+		{"missing field", "owner", OpEq, "x", []string{}},
+	}
+
+	c := newQueryTestCollection(t)
+
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			for _, field := range []string{"state", "fleet", "name"} {
+				if err := c.CreateIndex(IndexDefinition{Name: field, Fields: []string{field}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		for _, test := range tests {
+			t.Run(fmt.Sprintf("%s indexed=%v", test.name, indexed), func(t *testing.T) {
+				var carriers []queryTestCarrier
+				if _, err := c.Query().Where(test.field, test.operator, test.value).Find(&carriers); err != nil {
+					t.Fatal(err)
+				}
+
+				// Index scans return records in index order
+				got := carrierNames(carriers)
+				if indexed {
+					got = carrierNames(sortedByName(carriers))
+				}
+				if !reflect.DeepEqual(got, test.want) {
+					t.Errorf("got %v, want %v", got, test.want)
+				}
+			})
+		}
+	}
+}
+
+func sortedByName(carriers []queryTestCarrier) []queryTestCarrier {
+	sorted := append([]queryTestCarrier(nil), carriers...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+func TestQueryInvalidOperator(t *testing.T) {
+	c := newQueryTestCollection(t)
+
+	var carriers []queryTestCarrier
+	if _, err := c.Query().Where("fleet", "!=", 3).Find(&carriers); err == nil {
+		t.Error("expected an error for an unknown operator")
+	}
+	if _, err := c.Query().Where("fleet", OpIn, 3).Find(&carriers); err == nil {
+		t.Error("expected an error for in with a non-slice value")
+	}
+}
+
+// pages reads every page of the query built by query, returning the names on
+// each page
+func pages(t *testing.T, query func() *Query) [][]string {
+	t.Helper()
+
+	var all [][]string
+	cursor := ""
+	for {
+		var carriers []queryTestCarrier
+		next, err := query().After(cursor).Find(&carriers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, carrierNames(carriers))
+
+		if next == "" {
+			return all
+		}
+		if len(all) > 10 {
+			t.Fatal("cursor doesn't advance")
+		}
+		cursor = next
+	}
+}
+
+func TestQueryCursors(t *testing.T) {
+	tests := []struct {
+		name       string
+		index      *IndexDefinition
+		where      func(q *Query) *Query
+		orderBy    string
+		descending bool
+		want       [][]string
+	}{
+		{
+			name: "key order",
+			want: [][]string{{"Acme", "Bolt"}, {"Crest", "Delta"}, {"Echo", "Fern"}},
+		},
+		{
+			name:    "sorted in memory ascending",
+			orderBy: "fleet",
+			want:    [][]string{{"Bolt", "Echo"}, {"Delta", "Acme"}, {"Fern", "Crest"}},
+		},
+		{
+			name:       "sorted in memory descending",
+			orderBy:    "fleet",
+			descending: true,
+			want:       [][]string{{"Crest", "Fern"}, {"Acme", "Delta"}, {"Echo", "Bolt"}},
+		},
+		{
+			name:    "index ascending",
+			index:   &IndexDefinition{Name: "fleet", Fields: []string{"fleet"}},
+			orderBy: "fleet",
+			want:    [][]string{{"Bolt", "Echo"}, {"Delta", "Acme"}, {"Fern", "Crest"}},
+		},
+		{
+			name:       "index descending",
+			index:      &IndexDefinition{Name: "fleet", Fields: []string{"fleet"}},
+			orderBy:    "fleet",
+			descending: true,
+			want:       [][]string{{"Crest", "Fern"}, {"Acme", "Delta"}, {"Echo", "Bolt"}},
+		},
+		{
+			name:  "index range",
+			index: &IndexDefinition{Name: "state_fleet", Fields: []string{"state", "fleet"}},
+			where: func(q *Query) *Query {
+				return q.Where("state", OpEq, "IL").Where("fleet", OpGte, 3)
+			},
+			orderBy:    "fleet",
+			descending: true,
+			want:       [][]string{{"Crest", "Acme"}, {"Echo"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newQueryTestCollection(t)
+			if test.index != nil {
+				if err := c.CreateIndex(*test.index); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := pages(t, func() *Query {
+				q := c.Query().Limit(2)
+				if test.where != nil {
+					q = test.where(q)
+				}
+				if test.orderBy != "" {
+					q = q.OrderBy(test.orderBy, test.descending)
+				}
+				return q
+			})
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestQueryInvalidCursor(t *testing.T) {
+	c := newQueryTestCollection(t)
+
+	var carriers []queryTestCarrier
+	_, err := c.Query().After("not a cursor!").Find(&carriers)
+	if !errors.Is(err, ErrInvalidQueryCursor) {
+		t.Errorf("got %v, want %v", err, ErrInvalidQueryCursor)
+	}
+}
+
+func TestQueryCursorFromOtherPlan(t *testing.T) {
+	c := newQueryTestCollection(t)
+
+	var carriers []queryTestCarrier
+	cursor, err := c.Query().Where("fleet", OpGte, 3).Limit(2).Find(&carriers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor == "" {
+		t.Fatal("expected a cursor")
+	}
+
+	// The same query now reads the index, so key order cursors don't apply
+	if err := c.CreateIndex(IndexDefinition{Name: "fleet", Fields: []string{"fleet"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Query().Where("fleet", OpGte, 3).Limit(2).After(cursor).Find(&carriers)
+	if !errors.Is(err, ErrInvalidQueryCursor) {
+		t.Errorf("got %v, want %v", err, ErrInvalidQueryCursor)
+	}
+}
+
+func TestQueryOffsetAndCount(t *testing.T) {
+	c := newQueryTestCollection(t)
+
+	var carriers []queryTestCarrier
+	if _, err := c.Query().OrderBy("name", false).Offset(4).Find(&carriers); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := carrierNames(carriers), []string{"Echo", "Fern"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	count, err := c.Query().Where("state", OpEq, "IL").Limit(1).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("got count %d, want 3", count)
+	}
+}
+
+func TestQueryExplain(t *testing.T) {
+	c := newQueryTestCollection(t)
+	if err := c.CreateIndex(IndexDefinition{Name: "state_fleet", Fields: []string{"state", "fleet"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query *Query
+		want  QueryPlan
+		text  string
+	}{
+		{
+			name:  "full scan",
+			query: c.Query().Where("name", OpEq, "Acme"),
+			want:  QueryPlan{Filters: []string{"name = Acme"}, Sort: "key"},
+			text:  "full scan, filter name = Acme, sort by key",
+		},
+		{
+			name:  "sorted in memory",
+			query: c.Query().OrderBy("fleet", false),
+			want:  QueryPlan{Sort: "memory"},
+			text:  "full scan, sort by memory",
+		},
+		{
+			name:  "index equality and range",
+			query: c.Query().Where("state", OpEq, "IL").Where("fleet", OpGt, 5).OrderBy("fleet", false),
+			want: QueryPlan{
+				Index:         "state_fleet",
+				IndexEquality: []string{"state"},
+				IndexRange:    "fleet",
+				Filters:       []string{"state = IL", "fleet > 5"},
+				Sort:          "index",
+			},
+			text: "index scan on state_fleet equality=state range=fleet, filter state = IL and fleet > 5, sort by index",
+		},
+		{
+			name:  "sparse index not usable",
+			query: c.Query().Where("state", OpEq, "IL"),
+			want:  QueryPlan{Filters: []string{"state = IL"}, Sort: "key"},
+			text:  "full scan, filter state = IL, sort by key",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan, err := test.query.Explain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*plan, test.want) {
+				t.Errorf("got %+v, want %+v", *plan, test.want)
+			}
+			if plan.String() != test.text {
+				t.Errorf("got %q, want %q", plan.String(), test.text)
+			}
+		})
+	}
+}
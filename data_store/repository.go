@@ -0,0 +1,187 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Collection is a typed view of the records in a collection. All records in
+// the collection are expected to be of type T.
+type Collection[T any] struct {
+	dao *CollectionDao
+}
+
+func NewCollection[T any](c *CollectionDao) *Collection[T] {
+	return &Collection[T]{
+		dao: c,
+	}
+}
+
+// Get returns the record with key, or ErrNotFound
+func (c *Collection[T]) Get(key string) (T, error) {
+	var record T
+	if err := c.dao.GetRecord(key, &record); err != nil {
+		var empty T
+		return empty, err
+	}
+
+	return record, nil
+}
+
+func (c *Collection[T]) Put(key string, record T) error {
+	return c.dao.SetRecord(key, record)
+}
+
+// PutWithTTL writes record, which expires after ttl
+func (c *Collection[T]) PutWithTTL(key string, record T, ttl time.Duration) error {
+	return c.dao.SetRecordWithTTL(key, record, ttl)
+}
+
+func (c *Collection[T]) Delete(key string) error {
+	return c.dao.DeleteRecord(key)
+}
+
+// List returns all records with prefix, in key order
+func (c *Collection[T]) List(prefix string) ([]T, error) {
+	records := []T{}
+	if err := c.dao.GetRecords(prefix, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (c *Collection[T]) ListPage(options PageOptions) ([]T, *PageInfo, error) {
+	records := []T{}
+	info, err := c.dao.GetRecordsPage(options, &records)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return records, info, nil
+}
+
+// Iterate calls handler for each record with prefix, in key order, until
+// handler returns an error. The records are read in a single read-only
+// transaction, so handler must not write to the store.
+func (c *Collection[T]) Iterate(prefix string, handler func(key string, record T) error) error {
+	return c.dao.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.dao.bucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := b.Cursor()
+
+		live := c.dao.liveness(tx)
+
+		prefix := []byte(prefix)
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			// Nested collections have nil values
+			if value == nil || !live(key) {
+				continue
+			}
+
+			var record T
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("record %q: %w", key, err)
+			}
+
+			if err := handler(string(key), record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Lookup returns the records whose indexed fields equal values, in index
+// order
+func (c *Collection[T]) Lookup(index string, values ...any) ([]T, error) {
+	records := []T{}
+	if err := c.dao.Lookup(index, values, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// LookupOne returns the record whose indexed fields equal values, or
+// ErrNotFound
+func (c *Collection[T]) LookupOne(index string, values ...any) (T, error) {
+	var record T
+	if err := c.dao.LookupOne(index, values, &record); err != nil {
+		var empty T
+		return empty, err
+	}
+
+	return record, nil
+}
+
+func (c *Collection[T]) Range(index string, r IndexRange) ([]T, error) {
+	records := []T{}
+	if err := c.dao.Range(index, r, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (c *Collection[T]) Query() *Query {
+	return c.dao.Query()
+}
+
+// Find returns the records selected by q, which must be a query of this
+// collection, and a cursor for the next page if there is one
+func (c *Collection[T]) Find(q *Query) ([]T, string, error) {
+	records := []T{}
+	next, err := q.Find(&records)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return records, next, nil
+}
+
+// GetVersion returns the record with key and its version, or ErrNotFound
+func (c *Collection[T]) GetVersion(key string) (T, uint64, error) {
+	var record T
+	version, err := c.dao.GetRecordVersion(key, &record)
+	if err != nil {
+		var empty T
+		return empty, 0, err
+	}
+
+	return record, version, nil
+}
+
+// PutIfVersion writes record if the stored record has version, or returns
+// ErrConflict. The record keeps its expiry.
+func (c *Collection[T]) PutIfVersion(key string, record T, version uint64) (uint64, error) {
+	return c.dao.UpdateIfVersion(key, record, version)
+}
+
+// Insert writes record if there is no record with key, or returns ErrConflict
+func (c *Collection[T]) Insert(key string, record T) (uint64, error) {
+	return c.dao.Insert(key, record)
+}
+
+// Update applies update to the record with key, retrying if the record is
+// written concurrently, and returns the updated record and its version. The
+// record keeps its expiry, unlike with Put.
+func (c *Collection[T]) Update(key string, update func(record *T) error) (T, uint64, error) {
+	var record T
+	version, err := c.dao.UpdateFunc(key, &record, func() error {
+		return update(&record)
+	})
+	if err != nil {
+		var empty T
+		return empty, 0, err
+	}
+
+	return record, version, nil
+}
@@ -0,0 +1,332 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Record metadata is kept in a system bucket alongside the collection, with a
+// nested bucket per collection keyed by record key. Expiring records also
+// have an entry in the expiry bucket, keyed by the big-endian expiry time
+// followed by the record key, so that the sweeper reads them in expiry order.
+const (
+	recordsMetaBucket = "_records_meta"
+	expiryBucket      = "_expiry"
+)
+
+// How many expired records the sweeper deletes per transaction
+const sweepBatchSize = 1000
+
+type recordMeta struct {
+	// Unix time in nanoseconds, zero if the record doesn't expire
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// Taken from the collection's sequence on every write, so versions of a
+	// key only increase, even across deletes
+	Version uint64 `json:"version,omitempty"`
+}
+
+func (c *CollectionDao) SetRecordWithTTL(key string, record any, ttl time.Duration) error {
+	if ttl <= 0 {
+		return c.DeleteRecord(key)
+	}
+
+	jsonBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return c.dao.store.Update(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.putRecord(tx, b, []byte(key), jsonBytes, time.Now().Add(ttl))
+		return err
+	})
+}
+
+func (d *DocumentDao) SetRecordWithTTL(key string, record any, ttl time.Duration) error {
+	return d.defaultCollection.SetRecordWithTTL(key, record, ttl)
+}
+
+func (c *CollectionDao) meta(tx *bolt.Tx, key []byte) (*recordMeta, error) {
+	meta := &recordMeta{}
+
+	b := nestedBucket(tx, recordsMetaBucket, c.name)
+	if b == nil {
+		return meta, nil
+	}
+
+	if value := b.Get(key); value != nil {
+		if err := json.Unmarshal(value, meta); err != nil {
+			return nil, err
+		}
+	}
+
+	return meta, nil
+}
+
+// updateMeta gives the record at key, which has just been written to b, a new
+// version and replaces its expiry. A zero expiresAt removes the expiry.
+func (c *CollectionDao) updateMeta(tx *bolt.Tx, b *bolt.Bucket, key []byte, expiresAt time.Time) (uint64, error) {
+	meta, err := c.meta(tx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if meta.ExpiresAt != 0 {
+		if expiry := nestedBucket(tx, expiryBucket, c.name); expiry != nil {
+			if err := expiry.Delete(expiryKey(meta.ExpiresAt, key)); err != nil {
+				return 0, err
+			}
+		}
+		meta.ExpiresAt = 0
+	}
+
+	if !expiresAt.IsZero() {
+		meta.ExpiresAt = expiresAt.UnixNano()
+
+		expiry, err := createNestedBucket(tx, expiryBucket, c.name)
+		if err != nil {
+			return 0, err
+		}
+		if err := expiry.Put(expiryKey(meta.ExpiresAt, key), key); err != nil {
+			return 0, err
+		}
+	}
+
+	if meta.Version, err = b.NextSequence(); err != nil {
+		return 0, err
+	}
+
+	return meta.Version, c.putMeta(tx, key, meta)
+}
+
+func (c *CollectionDao) putMeta(tx *bolt.Tx, key []byte, meta *recordMeta) error {
+	if *meta == (recordMeta{}) {
+		return c.deleteMeta(tx, key)
+	}
+
+	b, err := createNestedBucket(tx, recordsMetaBucket, c.name)
+	if err != nil {
+		return err
+	}
+
+	jsonBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key, jsonBytes)
+}
+
+func (c *CollectionDao) deleteMeta(tx *bolt.Tx, key []byte) error {
+	b := nestedBucket(tx, recordsMetaBucket, c.name)
+	if b == nil {
+		return nil
+	}
+
+	meta, err := c.meta(tx, key)
+	if err != nil {
+		return err
+	}
+
+	if meta.ExpiresAt != 0 {
+		if expiry := nestedBucket(tx, expiryBucket, c.name); expiry != nil {
+			if err := expiry.Delete(expiryKey(meta.ExpiresAt, key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return b.Delete(key)
+}
+
+// liveness returns a function reporting whether the record at key has not
+// expired. Expired records are treated as missing until they are swept.
+//
+// The expiry bucket is read in expiry order up to now, so collections without
+// expired records pay nothing per key. If the sweeper has fallen far behind,
+// the metadata of each key is checked instead of holding every expired key.
+func (c *CollectionDao) liveness(tx *bolt.Tx) func(key []byte) bool {
+	live := func(key []byte) bool {
+		return true
+	}
+
+	expiry := nestedBucket(tx, expiryBucket, c.name)
+	if expiry == nil {
+		return live
+	}
+
+	now := time.Now().UnixNano()
+	// Expiry keys of records that expired by now sort before this
+	bound := expiryKey(now+1, nil)
+
+	expired := map[string]bool{}
+	cursor := expiry.Cursor()
+	for k, v := cursor.First(); k != nil && bytes.Compare(k, bound) < 0; k, v = cursor.Next() {
+		if len(expired) == sweepBatchSize {
+			return c.metaLiveness(tx, now)
+		}
+		expired[string(v)] = true
+	}
+
+	if len(expired) == 0 {
+		return live
+	}
+
+	return func(key []byte) bool {
+		return !expired[string(key)]
+	}
+}
+
+// metaLiveness is liveness read from the metadata of each key
+func (c *CollectionDao) metaLiveness(tx *bolt.Tx, now int64) func(key []byte) bool {
+	b := nestedBucket(tx, recordsMetaBucket, c.name)
+	if b == nil {
+		return func(key []byte) bool {
+			return true
+		}
+	}
+
+	return func(key []byte) bool {
+		value := b.Get(key)
+		if value == nil {
+			return true
+		}
+
+		var meta recordMeta
+		if err := json.Unmarshal(value, &meta); err != nil {
+			return true
+		}
+
+		return meta.ExpiresAt == 0 || meta.ExpiresAt > now
+	}
+}
+
+func expiryKey(expiresAt int64, key []byte) []byte {
+	expiry := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(expiry, uint64(expiresAt))
+
+	return append(expiry, key...)
+}
+
+// SweepExpired deletes expired records from every collection and returns how
+// many were deleted
+func (d *DocumentDao) SweepExpired() (int, error) {
+	var names []string
+
+	err := d.store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(expiryBucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	swept := 0
+	for _, name := range names {
+		count, err := d.Collection(name).sweepExpired()
+		swept += count
+		if err != nil {
+			return swept, err
+		}
+	}
+
+	return swept, nil
+}
+
+func (c *CollectionDao) sweepExpired() (int, error) {
+	swept := 0
+
+	for {
+		count := 0
+
+		err := c.dao.store.Update(func(tx *bolt.Tx) error {
+			expiry := nestedBucket(tx, expiryBucket, c.name)
+			if expiry == nil {
+				return nil
+			}
+
+			b, err := c.bucket(tx)
+			if err != nil {
+				return err
+			}
+
+			// Collect the keys first, deleting records removes expiry entries
+			now := expiryKey(time.Now().UnixNano(), nil)
+			var keys [][]byte
+
+			cursor := expiry.Cursor()
+			for k, key := cursor.First(); k != nil && bytes.Compare(k[:8], now) <= 0 && len(keys) < sweepBatchSize; k, key = cursor.Next() {
+				keys = append(keys, append([]byte(nil), key...))
+			}
+
+			for _, key := range keys {
+				if err := c.deleteRecord(tx, b, key, nil); err != nil {
+					return err
+				}
+			}
+
+			count = len(keys)
+			return nil
+		})
+		swept += count
+		if err != nil || count < sweepBatchSize {
+			return swept, err
+		}
+	}
+}
+
+// StartExpirySweeper deletes expired records every interval until the store
+// is closed or the returned function is called
+func (d *DocumentDao) StartExpirySweeper(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := d.SweepExpired(); err != nil {
+					log.Printf("error sweeping expired records: %v", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+
+	d.mutex.Lock()
+	d.stopFuncs = append(d.stopFuncs, stop)
+	d.mutex.Unlock()
+
+	return stop
+}
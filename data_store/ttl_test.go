@@ -0,0 +1,121 @@
+package data_store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type ttlTestSession struct {
+	User string `json:"user"`
+}
+
+func TestExpiredRecordsAreHidden(t *testing.T) {
+	c := newTestCollection(t, "sessions", map[string]any{
+		"permanent": ttlTestSession{User: "a"},
+	})
+
+	if err := c.SetRecordWithTTL("short", ttlTestSession{User: "b"}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetRecordWithTTL("long", ttlTestSession{User: "c"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var session ttlTestSession
+	if err := c.GetRecord("short", &session); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get expired record: got %v, want %v", err, ErrNotFound)
+	}
+	if err := c.GetRecord("long", &session); err != nil {
+		t.Errorf("get live record: %v", err)
+	}
+
+	var sessions []ttlTestSession
+	if err := c.GetRecords("", &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Errorf("got %d records, want 2", len(sessions))
+	}
+
+	count, err := c.Count("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got count %d, want 2", count)
+	}
+}
+
+func TestSetRecordRemovesExpiry(t *testing.T) {
+	c := newTestCollection(t, "sessions", nil)
+
+	if err := c.SetRecordWithTTL("a", ttlTestSession{User: "a"}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetRecord("a", ttlTestSession{User: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var session ttlTestSession
+	if err := c.GetRecord("a", &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.User != "b" {
+		t.Errorf("got user %q, want b", session.User)
+	}
+}
+
+func TestSetRecordWithoutTTLDeletes(t *testing.T) {
+	c := newTestCollection(t, "sessions", map[string]any{
+		"a": ttlTestSession{User: "a"},
+	})
+
+	if err := c.SetRecordWithTTL("a", ttlTestSession{User: "b"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var session ttlTestSession
+	if err := c.GetRecord("a", &session); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestSweepExpired(t *testing.T) {
+	c := newTestCollection(t, "sessions", map[string]any{
+		"permanent": ttlTestSession{User: "a"},
+	})
+
+	for _, key := range []string{"b", "c"} {
+		if err := c.SetRecordWithTTL(key, ttlTestSession{User: key}, time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.SetRecordWithTTL("d", ttlTestSession{User: "d"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	swept, err := c.dao.SweepExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swept != 2 {
+		t.Errorf("swept %d records, want 2", swept)
+	}
+
+	// Nothing is left to sweep
+	if swept, err = c.dao.SweepExpired(); err != nil || swept != 0 {
+		t.Errorf("second sweep: swept %d records, err %v", swept, err)
+	}
+
+	count, err := c.Count("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got count %d, want 2", count)
+	}
+}
@@ -0,0 +1,178 @@
+package data_store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Every write gives a record a new, higher version. Versions are compared to
+// detect concurrent writes: a write made with the version that was read
+// fails with ErrConflict if the record has been written since.
+
+var ErrConflict = errors.New("version conflict")
+
+// How many times UpdateFunc retries after a conflict
+const maxUpdateAttempts = 10
+
+// GetRecordVersion reads the record at key into destination and returns its
+// version. Records written before versions were kept have version 0.
+func (c *CollectionDao) GetRecordVersion(key string, destination any) (uint64, error) {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return 0, fmt.Errorf("non-pointer destination")
+	}
+
+	var version uint64
+
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		value, meta, err := c.versionedRecord(tx, b, []byte(key))
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return ErrNotFound
+		}
+
+		if err := json.NewDecoder(bytes.NewBuffer(value)).Decode(destination); err != nil {
+			return err
+		}
+
+		version = meta.Version
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// UpdateIfVersion writes record if the record at key has the given version,
+// and returns the new version. It returns ErrNotFound if there is no record
+// and ErrConflict if the record has another version. Unlike SetRecord, it
+// keeps the record's expiry.
+func (c *CollectionDao) UpdateIfVersion(key string, record any, version uint64) (uint64, error) {
+	return c.writeIf(key, record, func(value []byte, current uint64) error {
+		if value == nil {
+			return ErrNotFound
+		}
+		if current != version {
+			return fmt.Errorf("record %q has version %d, not %d: %w", key, current, version, ErrConflict)
+		}
+		return nil
+	})
+}
+
+// Insert writes record if there is no record at key, and returns its version.
+// It returns ErrConflict if there is.
+func (c *CollectionDao) Insert(key string, record any) (uint64, error) {
+	return c.writeIf(key, record, func(value []byte, current uint64) error {
+		if value != nil {
+			return fmt.Errorf("record %q already exists: %w", key, ErrConflict)
+		}
+		return nil
+	})
+}
+
+// UpdateFunc reads the record at key into destination, calls update to modify
+// it and writes it back if the record hasn't been written in the meantime.
+// Otherwise it reads the record again and retries, up to maxUpdateAttempts
+// times. It returns the new version. The record's expiry is kept.
+func (c *CollectionDao) UpdateFunc(key string, destination any, update func() error) (uint64, error) {
+	if reflect.TypeOf(destination).Kind() != reflect.Ptr {
+		return 0, fmt.Errorf("non-pointer destination")
+	}
+
+	for attempt := 0; ; attempt++ {
+		// Decode into a zero value, not over the previous attempt
+		reflect.ValueOf(destination).Elem().Set(reflect.Zero(reflect.TypeOf(destination).Elem()))
+
+		version, err := c.GetRecordVersion(key, destination)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := update(); err != nil {
+			return 0, err
+		}
+
+		version, err = c.UpdateIfVersion(key, destination, version)
+		if errors.Is(err, ErrConflict) && attempt+1 < maxUpdateAttempts {
+			continue
+		}
+
+		return version, err
+	}
+}
+
+// writeIf writes record in a transaction in which check approves the current
+// value and version of the record. The value is nil if there is no record.
+// An existing record keeps its expiry.
+func (c *CollectionDao) writeIf(key string, record any, check func(value []byte, version uint64) error) (uint64, error) {
+	jsonBytes, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+
+	var version uint64
+
+	err = c.dao.store.Update(func(tx *bolt.Tx) error {
+		b, err := c.bucket(tx)
+		if err != nil {
+			return err
+		}
+
+		value, meta, err := c.versionedRecord(tx, b, []byte(key))
+		if err != nil {
+			return err
+		}
+
+		if err := check(value, meta.Version); err != nil {
+			return err
+		}
+
+		var expiresAt time.Time
+		if value != nil && meta.ExpiresAt != 0 {
+			expiresAt = time.Unix(0, meta.ExpiresAt)
+		}
+
+		version, err = c.putRecord(tx, b, []byte(key), jsonBytes, expiresAt)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// versionedRecord returns the value and metadata of the record at key. The
+// value is nil and the metadata empty if there is no record or it has
+// expired.
+func (c *CollectionDao) versionedRecord(tx *bolt.Tx, b *bolt.Bucket, key []byte) ([]byte, *recordMeta, error) {
+	value := b.Get(key)
+	if value == nil {
+		return nil, &recordMeta{}, nil
+	}
+
+	meta, err := c.meta(tx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if meta.ExpiresAt != 0 && meta.ExpiresAt <= time.Now().UnixNano() {
+		return nil, &recordMeta{}, nil
+	}
+
+	return value, meta, nil
+}
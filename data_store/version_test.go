@@ -0,0 +1,230 @@
+package data_store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type versionTestCounter struct {
+	Count int `json:"count"`
+}
+
+// expiresAt returns the expiry stored for the record at key, zero if none
+func expiresAt(t *testing.T, c *CollectionDao, key string) int64 {
+	t.Helper()
+
+	var meta *recordMeta
+	err := c.dao.store.View(func(tx *bolt.Tx) error {
+		var err error
+		meta, err = c.meta(tx, []byte(key))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta.ExpiresAt
+}
+
+func TestVersionsIncrease(t *testing.T) {
+	c := newTestCollection(t, "counters", nil)
+
+	first, err := c.Insert("a", versionTestCounter{Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := c.UpdateIfVersion("a", versionTestCounter{Count: 2}, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second <= first {
+		t.Errorf("version %d after %d", second, first)
+	}
+
+	// Versions keep increasing after the record is deleted and inserted again
+	if err := c.DeleteRecord("a"); err != nil {
+		t.Fatal(err)
+	}
+	third, err := c.Insert("a", versionTestCounter{Count: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third <= second {
+		t.Errorf("version %d after %d", third, second)
+	}
+
+	var counter versionTestCounter
+	version, err := c.GetRecordVersion("a", &counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != third || counter.Count != 3 {
+		t.Errorf("got version %d and count %d, want %d and 3", version, counter.Count, third)
+	}
+}
+
+func TestVersionConflicts(t *testing.T) {
+	c := newTestCollection(t, "counters", nil)
+
+	version, err := c.Insert("a", versionTestCounter{Count: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Insert("a", versionTestCounter{Count: 2}); !errors.Is(err, ErrConflict) {
+		t.Errorf("insert over a record: got %v, want %v", err, ErrConflict)
+	}
+
+	if _, err := c.UpdateIfVersion("a", versionTestCounter{Count: 2}, version); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateIfVersion("a", versionTestCounter{Count: 3}, version); !errors.Is(err, ErrConflict) {
+		t.Errorf("update with a stale version: got %v, want %v", err, ErrConflict)
+	}
+
+	if _, err := c.UpdateIfVersion("b", versionTestCounter{Count: 1}, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update of a missing record: got %v, want %v", err, ErrNotFound)
+	}
+
+	var counter versionTestCounter
+	if _, err := c.GetRecordVersion("a", &counter); err != nil {
+		t.Fatal(err)
+	}
+	if counter.Count != 2 {
+		t.Errorf("got count %d, want 2", counter.Count)
+	}
+}
+
+func TestUpdateFuncRetries(t *testing.T) {
+	c := newTestCollection(t, "counters", nil)
+	if _, err := c.Insert("a", versionTestCounter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first update races with another writer and is retried
+	var counter versionTestCounter
+	calls := 0
+	_, err := c.UpdateFunc("a", &counter, func() error {
+		calls++
+		if calls == 1 {
+			if err := c.SetRecord("a", versionTestCounter{Count: 10}); err != nil {
+				return err
+			}
+		}
+		counter.Count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || counter.Count != 11 {
+		t.Errorf("got %d calls and count %d, want 2 and 11", calls, counter.Count)
+	}
+}
+
+func TestUpdateFuncRetryLimit(t *testing.T) {
+	c := newTestCollection(t, "counters", nil)
+	if _, err := c.Insert("a", versionTestCounter{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every attempt races with another writer
+	var counter versionTestCounter
+	calls := 0
+	_, err := c.UpdateFunc("a", &counter, func() error {
+		calls++
+		return c.SetRecord("a", versionTestCounter{Count: calls})
+	})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("got %v, want %v", err, ErrConflict)
+	}
+	if calls != maxUpdateAttempts {
+		t.Errorf("got %d attempts, want %d", calls, maxUpdateAttempts)
+	}
+
+	// Errors from update aren't retried
+	failure := errors.New("failed")
+	calls = 0
+	_, err = c.UpdateFunc("a", &counter, func() error {
+		calls++
+		return failure
+	})
+	if !errors.Is(err, failure) || calls != 1 {
+		t.Errorf("got %v after %d calls, want %v after 1", err, calls, failure)
+	}
+}
+
+func TestVersionsOfExpiredRecords(t *testing.T) {
+	c := newTestCollection(t, "counters", nil)
+
+	if err := c.SetRecordWithTTL("a", versionTestCounter{Count: 1}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	var counter versionTestCounter
+	if _, err := c.GetRecordVersion("a", &counter); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get: got %v, want %v", err, ErrNotFound)
+	}
+	if _, err := c.UpdateIfVersion("a", versionTestCounter{Count: 2}, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update: got %v, want %v", err, ErrNotFound)
+	}
+	_, err := c.UpdateFunc("a", &counter, func() error {
+		t.Error("update called for an expired record")
+		return nil
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("update func: got %v, want %v", err, ErrNotFound)
+	}
+
+	// An expired record can be inserted over, and the new one doesn't expire
+	if _, err := c.Insert("a", versionTestCounter{Count: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if expiry := expiresAt(t, c, "a"); expiry != 0 {
+		t.Errorf("inserted record expires at %d", expiry)
+	}
+}
+
+func TestVersionedWritesKeepExpiry(t *testing.T) {
+	c := newTestCollection(t, "counters", nil)
+
+	if err := c.SetRecordWithTTL("a", versionTestCounter{Count: 1}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	expiry := expiresAt(t, c, "a")
+
+	var counter versionTestCounter
+	version, err := c.GetRecordVersion("a", &counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UpdateIfVersion("a", versionTestCounter{Count: 2}, version); err != nil {
+		t.Fatal(err)
+	}
+	if got := expiresAt(t, c, "a"); got != expiry {
+		t.Errorf("after UpdateIfVersion: expires at %d, want %d", got, expiry)
+	}
+
+	counters := NewCollection[versionTestCounter](c)
+	if _, _, err := counters.Update("a", func(counter *versionTestCounter) error {
+		counter.Count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := expiresAt(t, c, "a"); got != expiry {
+		t.Errorf("after Update: expires at %d, want %d", got, expiry)
+	}
+
+	// Unversioned writes still replace the expiry
+	if err := c.SetRecord("a", versionTestCounter{Count: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if got := expiresAt(t, c, "a"); got != 0 {
+		t.Errorf("after SetRecord: expires at %d, want 0", got)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// How often the htpasswd file is checked for changes
+const htpasswdReloadInterval = time.Second
+
+// NewBasicAuthMiddleware returns an http.Handler that authenticates requests
+// with HTTP Basic auth against an htpasswd file. bcrypt and {SHA} entries are
+// supported. The file is reloaded when it changes on disk. Authenticated
+// requests carry a Principal named after the user.
+func NewBasicAuthMiddleware(htpasswdPath, realm string) (func(http.Handler) http.Handler, error) {
+	htpasswd := &htpasswdFile{path: htpasswdPath}
+	if err := htpasswd.load(); err != nil {
+		return nil, err
+	}
+
+	challenge := fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			htpasswd.reloadIfChanged()
+
+			username, password, ok := r.BasicAuth()
+			if !ok || !htpasswd.authenticate(username, password) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("no"))
+
+				return
+			}
+
+			next.ServeHTTP(w, withRequestPrincipal(r, &Principal{Name: username}))
+		})
+	}, nil
+}
+
+type htpasswdFile struct {
+	path string
+
+	mutex     sync.RWMutex
+	entries   map[string]string
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+func (h *htpasswdFile) authenticate(username, password string) bool {
+	h.mutex.RLock()
+	hash, ok := h.entries[username]
+	h.mutex.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(hash, "{SHA}")), []byte(expected)) == 1
+	default:
+		return false
+	}
+}
+
+func (h *htpasswdFile) reloadIfChanged() {
+	h.mutex.Lock()
+	if time.Since(h.checkedAt) < htpasswdReloadInterval {
+		h.mutex.Unlock()
+		return
+	}
+	h.checkedAt = time.Now()
+	h.mutex.Unlock()
+
+	info, err := os.Stat(h.path)
+	if err != nil {
+		log.Printf("error checking htpasswd file %q: %v", h.path, err)
+		return
+	}
+
+	h.mutex.RLock()
+	changed := !info.ModTime().Equal(h.modTime) || info.Size() != h.size
+	h.mutex.RUnlock()
+
+	if !changed {
+		return
+	}
+
+	// Keep serving the previous entries if the new file is broken
+	if err := h.load(); err != nil {
+		log.Printf("error reloading htpasswd file %q: %v", h.path, err)
+	}
+}
+
+func (h *htpasswdFile) load() error {
+	file, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]string)
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("malformed htpasswd entry on line %d", lineNumber)
+		}
+
+		username, hash := parts[0], parts[1]
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			log.Printf("skipping htpasswd entry for %q on line %d: unsupported hash format", username, lineNumber)
+			continue
+		}
+
+		entries[username] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	h.entries = entries
+	h.modTime = info.ModTime()
+	h.size = info.Size()
+	h.checkedAt = time.Now()
+	h.mutex.Unlock()
+
+	return nil
+}
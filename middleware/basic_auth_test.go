@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// writeHtpasswd writes an htpasswd file with a bcrypt entry for alice and a
+// {SHA} entry for bob, both with the password "secret"
+func writeHtpasswd(t *testing.T) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# users\nalice:" + string(hash) + "\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\ncarol:$apr1$unsupported\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	middleware, err := NewBasicAuthMiddleware(writeHtpasswd(t), "fleet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		noAuth     bool
+		wantStatus int
+	}{
+		{"bcrypt match", "alice", "secret", false, http.StatusOK},
+		{"bcrypt mismatch", "alice", "wrong", false, http.StatusUnauthorized},
+		{"sha match", "bob", "secret", false, http.StatusOK},
+		{"sha mismatch", "bob", "wrong", false, http.StatusUnauthorized},
+		{"unsupported hash", "carol", "secret", false, http.StatusUnauthorized},
+		{"unknown user", "dave", "secret", false, http.StatusUnauthorized},
+		{"no credentials", "", "", true, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if !test.noAuth {
+				request.SetBasicAuth(test.username, test.password)
+			}
+
+			var principal *Principal
+			recorder := httptest.NewRecorder()
+			middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal = PrincipalFromContext(r.Context())
+			})).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("got status %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if test.wantStatus != http.StatusOK {
+				if recorder.Header().Get("WWW-Authenticate") != `Basic realm="fleet", charset="UTF-8"` {
+					t.Errorf("got challenge %q", recorder.Header().Get("WWW-Authenticate"))
+				}
+				return
+			}
+			if principal == nil || principal.Name != test.username {
+				t.Errorf("got principal %+v, want %s", principal, test.username)
+			}
+		})
+	}
+}
+
+func TestBasicAuthMiddlewareRejectsMalformedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("no separator\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBasicAuthMiddleware(path, "fleet"); err == nil {
+		t.Error("accepted a malformed htpasswd file")
+	}
+	if _, err := NewBasicAuthMiddleware(filepath.Join(t.TempDir(), "missing"), "fleet"); err == nil {
+		t.Error("accepted a missing htpasswd file")
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import "net/http"
+
+// Middleware wraps an http.Handler with additional behavior
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middlewares so they can be applied to a handler in one go.
+// The first middleware in the chain is the outermost one.
+type Chain struct {
+	middlewares []Middleware
+}
+
+// NewChain returns a Chain of the given middlewares
+func NewChain(middlewares ...Middleware) Chain {
+	return Chain{
+		middlewares: append([]Middleware(nil), middlewares...),
+	}
+}
+
+// Append returns a new Chain with the given middlewares added to the end.
+// The original Chain is not modified.
+func (c Chain) Append(middlewares ...Middleware) Chain {
+	combined := make([]Middleware, 0, len(c.middlewares)+len(middlewares))
+	combined = append(combined, c.middlewares...)
+	combined = append(combined, middlewares...)
+
+	return Chain{
+		middlewares: combined,
+	}
+}
+
+// Then wraps handler with every middleware in the chain
+func (c Chain) Then(handler http.Handler) http.Handler {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// ThenFunc is a convenience wrapper around Then for http.HandlerFunc
+func (c Chain) ThenFunc(handlerFunc http.HandlerFunc) http.Handler {
+	if handlerFunc == nil {
+		return c.Then(nil)
+	}
+
+	return c.Then(handlerFunc)
+}
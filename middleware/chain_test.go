@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tagMiddleware appends name to the X-Order header before calling next
+func tagMiddleware(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Order", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	base := NewChain(tagMiddleware("a"), tagMiddleware("b"))
+
+	tests := []struct {
+		name  string
+		chain Chain
+		want  string
+	}{
+		{"empty", NewChain(), ""},
+		{"first is outermost", base, "a,b"},
+		{"append", base.Append(tagMiddleware("c")), "a,b,c"},
+		{"append to empty", NewChain().Append(tagMiddleware("c")), "c"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Order", "handler")
+			}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			want := "handler"
+			if test.want != "" {
+				want = test.want + ",handler"
+			}
+			if got := strings.Join(recorder.Header().Values("X-Order"), ","); got != want {
+				t.Errorf("got order %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestChainAppendDoesNotModifyOriginal(t *testing.T) {
+	base := NewChain(tagMiddleware("a"))
+	first := base.Append(tagMiddleware("b"))
+	second := base.Append(tagMiddleware("c"))
+
+	for _, test := range []struct {
+		chain Chain
+		want  string
+	}{
+		{base, "a"},
+		{first, "a,b"},
+		{second, "a,c"},
+	} {
+		recorder := httptest.NewRecorder()
+		test.chain.ThenFunc(func(http.ResponseWriter, *http.Request) {}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if got := strings.Join(recorder.Header().Values("X-Order"), ","); got != test.want {
+			t.Errorf("got order %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures NewCORSMiddleware
+type CORSOptions struct {
+	// AllowedOrigins may contain "*" to allow any origin
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+var defaultCORSHeaders = []string{"Accept", "Content-Type", "Access-Token", "Authorization", RequestIDHeader}
+
+// NewCORSMiddleware returns an http.Handler that answers CORS preflight
+// requests and adds CORS headers to responses for allowed origins
+func NewCORSMiddleware(options CORSOptions) func(http.Handler) http.Handler {
+	allowAnyOrigin := false
+	allowedOrigins := make(map[string]struct{}, len(options.AllowedOrigins))
+	for _, origin := range options.AllowedOrigins {
+		if origin == "*" {
+			allowAnyOrigin = true
+		}
+		allowedOrigins[strings.ToLower(origin)] = struct{}{}
+	}
+
+	allowedMethods := options.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = defaultCORSMethods
+	}
+
+	allowedHeaders := options.AllowedHeaders
+	if len(allowedHeaders) == 0 {
+		allowedHeaders = defaultCORSHeaders
+	}
+
+	methods := strings.Join(allowedMethods, ", ")
+	headers := strings.Join(allowedHeaders, ", ")
+	exposedHeaders := strings.Join(options.ExposedHeaders, ", ")
+
+	isAllowedMethod := func(method string) bool {
+		for _, allowed := range allowedMethods {
+			if strings.EqualFold(allowed, method) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			w.Header().Add("Vary", "Origin")
+
+			_, isAllowedOrigin := allowedOrigins[strings.ToLower(origin)]
+			if origin == "" || (!allowAnyOrigin && !isAllowedOrigin) {
+				if isPreflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Credentialed requests can't use a wildcard origin
+			if allowAnyOrigin && !options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !isPreflight {
+				if exposedHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			if !isAllowedMethod(r.Header.Get("Access-Control-Request-Method")) {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			if options.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge/time.Second)))
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	options := CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		ExposedHeaders: []string{RequestIDHeader},
+		MaxAge:         10 * time.Minute,
+	}
+	credentialed := CORSOptions{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	}
+
+	tests := []struct {
+		name          string
+		options       CORSOptions
+		method        string
+		origin        string
+		requestMethod string
+		wantStatus    int
+		wantHeaders   map[string]string
+	}{
+		{
+			name:        "no origin",
+			options:     options,
+			method:      http.MethodGet,
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:        "disallowed origin",
+			options:     options,
+			method:      http.MethodGet,
+			origin:      "https://evil.example.com",
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:       "allowed origin",
+			options:    options,
+			method:     http.MethodGet,
+			origin:     "https://App.example.com",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":   "https://App.example.com",
+				"Access-Control-Expose-Headers": RequestIDHeader,
+			},
+		},
+		{
+			name:          "preflight",
+			options:       options,
+			method:        http.MethodOptions,
+			origin:        "https://app.example.com",
+			requestMethod: http.MethodPut,
+			wantStatus:    http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, HEAD, POST, PUT, PATCH, DELETE",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:          "preflight from a disallowed origin",
+			options:       options,
+			method:        http.MethodOptions,
+			origin:        "https://evil.example.com",
+			requestMethod: http.MethodPut,
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "preflight of a disallowed method",
+			options:       options,
+			method:        http.MethodOptions,
+			origin:        "https://app.example.com",
+			requestMethod: "PURGE",
+			wantStatus:    http.StatusMethodNotAllowed,
+			wantHeaders:   map[string]string{"Access-Control-Allow-Methods": ""},
+		},
+		{
+			name:       "wildcard with credentials echoes the origin",
+			options:    credentialed,
+			method:     http.MethodGet,
+			origin:     "https://any.example.com",
+			wantStatus: http.StatusOK,
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Origin":      "https://any.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+		},
+		{
+			name:        "wildcard without credentials",
+			options:     CORSOptions{AllowedOrigins: []string{"*"}},
+			method:      http.MethodGet,
+			origin:      "https://any.example.com",
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, "/", nil)
+			if test.origin != "" {
+				request.Header.Set("Origin", test.origin)
+			}
+			if test.requestMethod != "" {
+				request.Header.Set("Access-Control-Request-Method", test.requestMethod)
+			}
+
+			recorder := httptest.NewRecorder()
+			NewCORSMiddleware(test.options)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.wantStatus)
+			}
+			for name, want := range test.wantHeaders {
+				if got := recorder.Header().Get(name); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+			if vary := recorder.Header().Values("Vary"); len(vary) == 0 || vary[0] != "Origin" {
+				t.Errorf("got Vary %v, want Origin first", vary)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// NewGzipMiddleware returns an http.Handler that gzip compresses responses for
+// clients that accept it. Responses that already carry a Content-Encoding, or
+// that have no body, are passed through untouched.
+func NewGzipMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if !acceptsGzip(r) || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w}
+			defer gw.Close()
+
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+
+		if len(parts) > 1 && strings.ReplaceAll(strings.TrimSpace(parts[1]), " ", "") == "q=0" {
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// gzipResponseWriter holds back the status until the first Write, Flush or
+// Close so the content type can be sniffed from the uncompressed body
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer      *gzip.Writer
+	status      int
+	wroteHeader bool
+	passthrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// writeHeader sends the status recorded by WriteHeader, or 200 if there isn't
+// one. If the response is compressed and has no Content-Type, it's sniffed from
+// b, otherwise net/http would sniff the gzip bytes.
+func (w *gzipResponseWriter) writeHeader(b []byte) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	header := w.Header()
+	hasBody := w.status != http.StatusNoContent && w.status != http.StatusNotModified && w.status >= http.StatusOK
+	if !hasBody || header.Get("Content-Encoding") != "" {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	if header.Get("Content-Type") == "" && len(b) > 0 {
+		header.Set("Content-Type", http.DetectContentType(b))
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+
+	w.writer = gzipWriterPool.Get().(*gzip.Writer)
+	w.writer.Reset(w.ResponseWriter)
+
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.writeHeader(b)
+
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.writer.Write(b)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	w.writeHeader(nil)
+
+	if w.writer != nil {
+		w.writer.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) Close() error {
+	// A status without a body is sent as is, there's nothing to compress
+	if !w.wroteHeader && w.status != 0 {
+		w.wroteHeader = true
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	if w.writer == nil {
+		return nil
+	}
+
+	err := w.writer.Close()
+	w.writer.Reset(nil)
+	gzipWriterPool.Put(w.writer)
+	w.writer = nil
+
+	return err
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	const html = "<!DOCTYPE html><html><body>hello</body></html>"
+
+	tests := []struct {
+		name            string
+		acceptEncoding  string
+		method          string
+		handler         http.HandlerFunc
+		wantStatus      int
+		wantCompressed  bool
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:           "not accepted",
+			acceptEncoding: "deflate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(html))
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        html,
+		},
+		{
+			name:           "refused with q=0",
+			acceptEncoding: "gzip;q=0, deflate",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("plain"))
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "plain",
+		},
+		{
+			name:           "sniffed from the uncompressed body",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(html))
+			},
+			wantStatus:      http.StatusOK,
+			wantCompressed:  true,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        html,
+		},
+		{
+			name:           "sniffed after WriteHeader",
+			acceptEncoding: "br, gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(html))
+			},
+			wantStatus:      http.StatusCreated,
+			wantCompressed:  true,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        html,
+		},
+		{
+			name:           "content type set by the handler",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"a":1}`))
+			},
+			wantStatus:      http.StatusOK,
+			wantCompressed:  true,
+			wantContentType: "application/json",
+			wantBody:        `{"a":1}`,
+		},
+		{
+			name:           "already encoded",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "br")
+				w.Write([]byte("brotli"))
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "brotli",
+		},
+		{
+			name:           "no content",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:           "status without a body",
+			acceptEncoding: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+			wantStatus: http.StatusAccepted,
+		},
+		{
+			name:           "head",
+			acceptEncoding: "gzip",
+			method:         http.MethodHead,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/plain",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			server := httptest.NewServer(NewGzipMiddleware()(test.handler))
+			defer server.Close()
+
+			request, err := http.NewRequest(method, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			response, err := http.DefaultTransport.RoundTrip(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != test.wantStatus {
+				t.Errorf("got status %d, want %d", response.StatusCode, test.wantStatus)
+			}
+			if compressed := response.Header.Get("Content-Encoding") == "gzip"; compressed != test.wantCompressed {
+				t.Errorf("got Content-Encoding %q, want compressed %v", response.Header.Get("Content-Encoding"), test.wantCompressed)
+			}
+			if test.wantContentType != "" && response.Header.Get("Content-Type") != test.wantContentType {
+				t.Errorf("got Content-Type %q, want %q", response.Header.Get("Content-Type"), test.wantContentType)
+			}
+			if response.Header.Get("Vary") != "Accept-Encoding" {
+				t.Errorf("got Vary %q, want Accept-Encoding", response.Header.Get("Vary"))
+			}
+
+			body := io.Reader(response.Body)
+			if test.wantCompressed {
+				reader, err := gzip.NewReader(response.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = reader
+			}
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != test.wantBody {
+				t.Errorf("got body %q, want %q", b, test.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPFilterOptions configures NewIPFilterMiddleware. Entries may be single IPs
+// or CIDR ranges.
+type IPFilterOptions struct {
+	// If set, only clients in these ranges are allowed
+	Allow []string
+	// Clients in these ranges are always rejected, even if allowed above
+	Deny []string
+	// X-Forwarded-For is only honored for requests arriving from these proxies
+	TrustedProxies []string
+}
+
+// NewIPFilterMiddleware returns an http.Handler that rejects requests whose
+// client IP is denied or not allowed
+func NewIPFilterMiddleware(options IPFilterOptions) (func(http.Handler) http.Handler, error) {
+	allow, err := parseNetworks(options.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := parseNetworks(options.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseNetworks(options.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r, trustedProxies)
+
+			isAllowed := ip != nil && !containsIP(deny, ip) && (len(allow) == 0 || containsIP(allow, ip))
+			if !isAllowed {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("no"))
+
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// clientIP returns the IP of the original client. X-Forwarded-For is walked
+// from the nearest hop backwards for as long as each hop is a trusted proxy,
+// so clients can't spoof their address by sending the header themselves.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+
+		ip = hop
+		if !containsIP(trustedProxies, ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilterMiddleware(t *testing.T) {
+	options := IPFilterOptions{
+		Allow:          []string{"10.0.0.0/8", "2001:db8::/32", "192.168.1.5"},
+		Deny:           []string{"10.0.0.13", "2001:db8:bad::/48"},
+		TrustedProxies: []string{"172.16.0.1", "fd00::1"},
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		wantStatus   int
+	}{
+		{"allowed range", "10.1.2.3:1234", nil, http.StatusOK},
+		{"allowed single IP", "192.168.1.5:1234", nil, http.StatusOK},
+		{"outside the allowed ranges", "192.168.1.6:1234", nil, http.StatusForbidden},
+		{"denied inside an allowed range", "10.0.0.13:1234", nil, http.StatusForbidden},
+		{"allowed IPv6", "[2001:db8::1]:1234", nil, http.StatusOK},
+		{"denied IPv6", "[2001:db8:bad::1]:1234", nil, http.StatusForbidden},
+		{"outside the allowed IPv6 ranges", "[2001:db9::1]:1234", nil, http.StatusForbidden},
+		{"unparseable remote address", "nonsense", nil, http.StatusForbidden},
+		{"forwarded by a trusted proxy", "172.16.0.1:1234", []string{"10.1.2.3"}, http.StatusOK},
+		{"denied client behind a trusted proxy", "172.16.0.1:1234", []string{"10.0.0.13"}, http.StatusForbidden},
+		{"forwarded by an IPv6 proxy", "[fd00::1]:1234", []string{"2001:db8::1"}, http.StatusOK},
+		{"forwarded by an untrusted proxy", "192.168.1.6:1234", []string{"10.1.2.3"}, http.StatusForbidden},
+		{"spoofed hop before the trusted proxy", "172.16.0.1:1234", []string{"10.1.2.3, 192.168.1.6"}, http.StatusForbidden},
+		{"chain of trusted proxies", "172.16.0.1:1234", []string{"10.1.2.3", "fd00::1"}, http.StatusOK},
+		{"trusted proxy is not itself allowed", "172.16.0.1:1234", nil, http.StatusForbidden},
+		{"garbage hop", "172.16.0.1:1234", []string{"not-an-ip"}, http.StatusForbidden},
+	}
+
+	middleware, err := NewIPFilterMiddleware(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			for _, forwardedFor := range test.forwardedFor {
+				request.Header.Add("X-Forwarded-For", forwardedFor)
+			}
+
+			recorder := httptest.NewRecorder()
+			middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIPFilterMiddlewareRejectsInvalidEntries(t *testing.T) {
+	for _, options := range []IPFilterOptions{
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"not-an-ip"}},
+		{TrustedProxies: []string{"300.0.0.1"}},
+	} {
+		if _, err := NewIPFilterMiddleware(options); err == nil {
+			t.Errorf("accepted %+v", options)
+		}
+	}
+}
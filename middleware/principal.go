@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name  string
+	Roles []string
+}
+
+// HasRole reports whether the principal has been granted role
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+type principalContextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal set by an authentication
+// middleware, or nil for unauthenticated requests
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
+
+func withRequestPrincipal(r *http.Request, principal *Principal) *http.Request {
+	return r.WithContext(WithPrincipal(r.Context(), principal))
+}
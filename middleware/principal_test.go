@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrincipalContext(t *testing.T) {
+	if principal := PrincipalFromContext(context.Background()); principal != nil {
+		t.Errorf("got %+v without a principal", principal)
+	}
+
+	principal := &Principal{Name: "alice", Roles: []string{"admin"}}
+	request := withRequestPrincipal(httptest.NewRequest(http.MethodGet, "/", nil), principal)
+	if got := PrincipalFromContext(request.Context()); got != principal {
+		t.Errorf("got %+v, want %+v", got, principal)
+	}
+}
+
+func TestPrincipalHasRole(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		role      string
+		want      bool
+	}{
+		{"granted", &Principal{Roles: []string{"reader", "admin"}}, "admin", true},
+		{"not granted", &Principal{Roles: []string{"reader"}}, "admin", false},
+		{"no roles", &Principal{}, "admin", false},
+		{"nil principal", nil, "admin", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.principal.HasRole(test.role); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QuotaStore persists usage counters. *data_store.DocumentDao satisfies it.
+type QuotaStore interface {
+	GetRecord(key string, destination interface{}) error
+	SetRecord(key string, record interface{}) error
+}
+
+type QuotaPeriod string
+
+const (
+	QuotaPeriodDay   = QuotaPeriod("day")
+	QuotaPeriodMonth = QuotaPeriod("month")
+)
+
+// Quota caps usage within a period. A zero limit means unlimited.
+type Quota struct {
+	Period   QuotaPeriod
+	Requests int64
+	Bytes    int64
+}
+
+// QuotaOptions configures NewQuotaMiddleware
+type QuotaOptions struct {
+	Store QuotaStore
+	// Quotas per principal name
+	Quotas map[string][]Quota
+	// Used for principals without an entry in Quotas
+	Default []Quota
+	// Prefix for counter keys in the store. Defaults to "quota:".
+	KeyPrefix string
+	// Reports whether an error from Store.GetRecord means the counter doesn't
+	// exist yet, e.g. errors.Is(err, data_store.ErrNotFound). Any other
+	// error fails the request, so a failed read never resets a counter.
+	// Required when Store is set.
+	IsNotFound func(err error) bool
+}
+
+// ErrQuotaIsNotFoundRequired is returned by NewQuotaMiddleware when a Store is
+// set without an IsNotFound func
+var ErrQuotaIsNotFoundRequired = errors.New("quota store requires an IsNotFound func")
+
+type quotaUsage struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// NewQuotaMiddleware returns an http.Handler that enforces per-principal
+// request and response byte quotas. It must be mounted behind an
+// authentication middleware. Usage headers are added to every response and
+// exhausted quotas are answered with a 429.
+//
+// Counters are read from and written to the store on every request. Updates
+// are serialized within a process but not across processes, so instances
+// sharing a store may lose concurrent increments and slightly undercount.
+func NewQuotaMiddleware(options QuotaOptions) (func(http.Handler) http.Handler, error) {
+	return newQuotaMiddleware(options, time.Now)
+}
+
+// newQuotaMiddleware is NewQuotaMiddleware with a clock, for tests
+func newQuotaMiddleware(options QuotaOptions, clock func() time.Time) (func(http.Handler) http.Handler, error) {
+	if options.Store != nil && options.IsNotFound == nil {
+		return nil, ErrQuotaIsNotFoundRequired
+	}
+
+	tracker := &quotaTracker{
+		store:      options.Store,
+		keyPrefix:  options.KeyPrefix,
+		isNotFound: options.IsNotFound,
+		usage:      make(map[string]*cachedQuotaUsage),
+	}
+	if tracker.keyPrefix == "" {
+		tracker.keyPrefix = "quota:"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := PrincipalFromContext(r.Context())
+			if principal == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("no"))
+
+				return
+			}
+
+			quotas, ok := options.Quotas[principal.Name]
+			if !ok {
+				quotas = options.Default
+			}
+			if len(quotas) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := clock().UTC()
+
+			exhaustedUntil, err := tracker.begin(principal.Name, quotas, now, w.Header())
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("no"))
+
+				return
+			}
+			if !exhaustedUntil.IsZero() {
+				w.Header().Set("Retry-After", strconv.Itoa(int(exhaustedUntil.Sub(now)/time.Second)+1))
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(http.StatusText(http.StatusTooManyRequests)))
+
+				return
+			}
+
+			counter := &byteCountingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(counter, r)
+
+			if err := tracker.addBytes(principal.Name, quotas, now, counter.bytes); err != nil {
+				// The response has been sent, so the error can only be logged
+				log.Printf("error recording response bytes for %q: %v", principal.Name, err)
+			}
+		})
+	}, nil
+}
+
+type quotaTracker struct {
+	store      QuotaStore
+	keyPrefix  string
+	isNotFound func(error) bool
+
+	mutex sync.Mutex
+	// Counters for when there is no store, by key
+	usage map[string]*cachedQuotaUsage
+	// Counters whose period has ended are dropped once a day
+	prunedDay string
+}
+
+type cachedQuotaUsage struct {
+	usage     *quotaUsage
+	periodEnd time.Time
+}
+
+// counters returns the counter of each quota, and the counters by key.
+// Quotas with the same period share a counter, so that a request or byte is
+// only counted once per period.
+func (t *quotaTracker) counters(name string, quotas []Quota, now time.Time) ([]*quotaUsage, map[string]*quotaUsage, error) {
+	usages := make([]*quotaUsage, len(quotas))
+	byKey := make(map[string]*quotaUsage)
+
+	for i, quota := range quotas {
+		key := t.key(name, quota.Period, now)
+
+		usage, ok := byKey[key]
+		if !ok {
+			var err error
+			if usage, err = t.load(key, quota.Period, now); err != nil {
+				return nil, nil, err
+			}
+			byKey[key] = usage
+		}
+		usages[i] = usage
+	}
+
+	return usages, byKey, nil
+}
+
+// begin counts a request against every quota, unless one of them is already
+// exhausted in which case the end of that quota's period is returned
+func (t *quotaTracker) begin(name string, quotas []Quota, now time.Time, header http.Header) (time.Time, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	usages, byKey, err := t.counters(name, quotas, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for i, quota := range quotas {
+		requestsExhausted := quota.Requests > 0 && usages[i].Requests >= quota.Requests
+		bytesExhausted := quota.Bytes > 0 && usages[i].Bytes >= quota.Bytes
+		if requestsExhausted || bytesExhausted {
+			setQuotaHeaders(header, quotas, usages, now)
+			return periodEnd(quota.Period, now), nil
+		}
+	}
+
+	for key, usage := range byKey {
+		usage.Requests++
+		if err := t.persist(key, usage); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	setQuotaHeaders(header, quotas, usages, now)
+
+	return time.Time{}, nil
+}
+
+func (t *quotaTracker) addBytes(name string, quotas []Quota, now time.Time, bytes int64) error {
+	if bytes == 0 {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	_, byKey, err := t.counters(name, quotas, now)
+	if err != nil {
+		return err
+	}
+
+	for key, usage := range byKey {
+		usage.Bytes += bytes
+		if err := t.persist(key, usage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// load must be called with the mutex held. Counters are read from the store
+// on every call so that processes sharing a store see each other's counts.
+func (t *quotaTracker) load(key string, period QuotaPeriod, now time.Time) (*quotaUsage, error) {
+	if t.store == nil {
+		if day := now.Format("2006-01-02"); day != t.prunedDay {
+			for cachedKey, cached := range t.usage {
+				if !now.Before(cached.periodEnd) {
+					delete(t.usage, cachedKey)
+				}
+			}
+			t.prunedDay = day
+		}
+
+		cached, ok := t.usage[key]
+		if !ok {
+			cached = &cachedQuotaUsage{usage: &quotaUsage{}, periodEnd: periodEnd(period, now)}
+			t.usage[key] = cached
+		}
+
+		return cached.usage, nil
+	}
+
+	usage := &quotaUsage{}
+	if err := t.store.GetRecord(key, usage); err != nil {
+		// A counter that doesn't exist yet starts at zero
+		if t.isNotFound(err) {
+			return &quotaUsage{}, nil
+		}
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+func (t *quotaTracker) persist(key string, usage *quotaUsage) error {
+	if t.store == nil {
+		return nil
+	}
+	return t.store.SetRecord(key, usage)
+}
+
+func (t *quotaTracker) key(name string, period QuotaPeriod, now time.Time) string {
+	return fmt.Sprintf("%s%s:%s", t.keyPrefix, name, periodKey(period, now))
+}
+
+func periodKey(period QuotaPeriod, now time.Time) string {
+	switch period {
+	case QuotaPeriodMonth:
+		return now.Format("2006-01")
+	default:
+		return now.Format("2006-01-02")
+	}
+}
+
+func periodEnd(period QuotaPeriod, now time.Time) time.Time {
+	switch period {
+	case QuotaPeriodMonth:
+		return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// setQuotaHeaders reports the most constrained request and byte quotas
+func setQuotaHeaders(header http.Header, quotas []Quota, usages []*quotaUsage, now time.Time) {
+	requestsIndex, bytesIndex := -1, -1
+
+	for i, quota := range quotas {
+		if quota.Requests > 0 && (requestsIndex == -1 || quota.Requests-usages[i].Requests < quotas[requestsIndex].Requests-usages[requestsIndex].Requests) {
+			requestsIndex = i
+		}
+		if quota.Bytes > 0 && (bytesIndex == -1 || quota.Bytes-usages[i].Bytes < quotas[bytesIndex].Bytes-usages[bytesIndex].Bytes) {
+			bytesIndex = i
+		}
+	}
+
+	if requestsIndex != -1 {
+		quota := quotas[requestsIndex]
+		header.Set("X-Quota-Limit", strconv.FormatInt(quota.Requests, 10))
+		header.Set("X-Quota-Remaining", strconv.FormatInt(nonNegative(quota.Requests-usages[requestsIndex].Requests), 10))
+		header.Set("X-Quota-Reset", strconv.FormatInt(periodEnd(quota.Period, now).Unix(), 10))
+	}
+
+	if bytesIndex != -1 {
+		quota := quotas[bytesIndex]
+		header.Set("X-Quota-Bytes-Limit", strconv.FormatInt(quota.Bytes, 10))
+		header.Set("X-Quota-Bytes-Remaining", strconv.FormatInt(nonNegative(quota.Bytes-usages[bytesIndex].Bytes), 10))
+		header.Set("X-Quota-Bytes-Reset", strconv.FormatInt(periodEnd(quota.Period, now).Unix(), 10))
+	}
+}
+
+func nonNegative(value int64) int64 {
+	if value < 0 {
+		return 0
+	}
+	return value
+}
+
+type byteCountingResponseWriter struct {
+	http.ResponseWriter
+	bytes int64
+}
+
+func (w *byteCountingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *byteCountingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var errTestNotFound = errors.New("not found")
+
+// testQuotaStore keeps counters as JSON, like a DocumentDao
+type testQuotaStore struct {
+	records map[string][]byte
+	readErr error
+}
+
+func (s *testQuotaStore) GetRecord(key string, destination interface{}) error {
+	if s.readErr != nil {
+		return s.readErr
+	}
+	record, ok := s.records[key]
+	if !ok {
+		return errTestNotFound
+	}
+	return json.Unmarshal(record, destination)
+}
+
+func (s *testQuotaStore) SetRecord(key string, record interface{}) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.records[key] = b
+	return nil
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+// serveQuota makes a request as principal and returns the response
+func serveQuota(t *testing.T, middleware func(http.Handler) http.Handler, principal string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if principal != "" {
+		request = withRequestPrincipal(request, &Principal{Name: principal})
+	}
+
+	recorder := httptest.NewRecorder()
+	middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	})).ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestQuotaMiddlewareRollover(t *testing.T) {
+	quotas := []Quota{
+		{Period: QuotaPeriodDay, Requests: 2},
+		{Period: QuotaPeriodMonth, Requests: 3},
+	}
+
+	stores := []struct {
+		name    string
+		options QuotaOptions
+	}{
+		{"in memory", QuotaOptions{Default: quotas}},
+		{"store", QuotaOptions{
+			Default:    quotas,
+			Store:      &testQuotaStore{records: make(map[string][]byte)},
+			IsNotFound: func(err error) bool { return errors.Is(err, errTestNotFound) },
+		}},
+	}
+
+	steps := []struct {
+		name       string
+		now        time.Time
+		wantStatus int
+		wantRetry  string
+	}{
+		{"first of the day", time.Date(2026, 1, 30, 10, 0, 0, 0, time.UTC), http.StatusOK, ""},
+		{"second of the day", time.Date(2026, 1, 30, 11, 0, 0, 0, time.UTC), http.StatusOK, ""},
+		{"day exhausted", time.Date(2026, 1, 30, 23, 59, 30, 0, time.UTC), http.StatusTooManyRequests, "31"},
+		{"next day", time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), http.StatusOK, ""},
+		{"month exhausted", time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC), http.StatusTooManyRequests, "3601"},
+		{"next month", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), http.StatusOK, ""},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			clock := &testClock{}
+			middleware, err := newQuotaMiddleware(store.options, clock.Now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, step := range steps {
+				clock.now = step.now
+
+				recorder := serveQuota(t, middleware, "alice", "ok")
+				if recorder.Code != step.wantStatus {
+					t.Fatalf("%s: got status %d, want %d", step.name, recorder.Code, step.wantStatus)
+				}
+				if retry := recorder.Header().Get("Retry-After"); retry != step.wantRetry {
+					t.Errorf("%s: got Retry-After %q, want %q", step.name, retry, step.wantRetry)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaMiddlewareHeadersAndBytes(t *testing.T) {
+	clock := &testClock{now: time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)}
+	middleware, err := newQuotaMiddleware(QuotaOptions{
+		Quotas: map[string][]Quota{
+			"alice": {{Period: QuotaPeriodDay, Requests: 10, Bytes: 5}},
+		},
+	}, clock.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := serveQuota(t, middleware, "alice", "hello")
+	want := map[string]string{
+		"X-Quota-Limit":           "10",
+		"X-Quota-Remaining":       "9",
+		"X-Quota-Reset":           strconv.FormatInt(time.Date(2026, 3, 11, 0, 0, 0, 0, time.UTC).Unix(), 10),
+		"X-Quota-Bytes-Limit":     "5",
+		"X-Quota-Bytes-Remaining": "5",
+	}
+	for name, value := range want {
+		if got := recorder.Header().Get(name); got != value {
+			t.Errorf("%s: got %q, want %q", name, got, value)
+		}
+	}
+
+	// The bytes of the first response exhaust the quota
+	if recorder := serveQuota(t, middleware, "alice", "hello"); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d after the byte quota was used, want %d", recorder.Code, http.StatusTooManyRequests)
+	}
+
+	// Principals without quotas aren't limited, and requests without one are rejected
+	if recorder := serveQuota(t, middleware, "bob", "hello"); recorder.Code != http.StatusOK {
+		t.Errorf("unlimited principal: got status %d", recorder.Code)
+	}
+	if recorder := serveQuota(t, middleware, "", "hello"); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("no principal: got status %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestQuotaMiddlewareStoreErrors(t *testing.T) {
+	store := &testQuotaStore{records: make(map[string][]byte)}
+	options := QuotaOptions{
+		Store:   store,
+		Default: []Quota{{Period: QuotaPeriodDay, Requests: 1}},
+	}
+
+	if _, err := NewQuotaMiddleware(options); !errors.Is(err, ErrQuotaIsNotFoundRequired) {
+		t.Fatalf("without IsNotFound: got %v, want %v", err, ErrQuotaIsNotFoundRequired)
+	}
+
+	options.IsNotFound = func(err error) bool { return errors.Is(err, errTestNotFound) }
+	middleware, err := NewQuotaMiddleware(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing counter starts at zero
+	if recorder := serveQuota(t, middleware, "alice", "ok"); recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d for a new counter", recorder.Code)
+	}
+
+	// Any other read error fails the request instead of resetting the counter
+	store.readErr = errors.New("disk on fire")
+	if recorder := serveQuota(t, middleware, "alice", "ok"); recorder.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d for a read error, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+
+	store.readErr = nil
+	if recorder := serveQuota(t, middleware, "alice", "ok"); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d after the read error, want %d", recorder.Code, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// NewRecoveryMiddleware returns an http.Handler that recovers from panics in
+// downstream handlers and responds with a 500 instead of dropping the
+// connection
+func NewRecoveryMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tracked := &statusTrackingResponseWriter{ResponseWriter: w}
+
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+
+				// The server uses this to abort a response on purpose, so let it through.
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				log.Printf("panic serving %s %s (request id %q): %v\n%s", r.Method, r.URL.Path, RequestIDFromContext(r.Context()), recovered, debug.Stack())
+
+				// Nothing useful can be sent once the handler has started the response.
+				if tracked.wroteHeader {
+					return
+				}
+
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(http.StatusText(http.StatusInternalServerError)))
+			}()
+
+			next.ServeHTTP(tracked, r)
+		})
+	}
+}
+
+// statusTrackingResponseWriter records whether a response has been started
+type statusTrackingResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int
+}
+
+func (w *statusTrackingResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusTrackingResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusTrackingResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.wroteHeader = true
+			w.status = http.StatusOK
+		}
+		flusher.Flush()
+	}
+}
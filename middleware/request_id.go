@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the header used to accept and return request IDs
+const RequestIDHeader = "X-Request-Id"
+
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// NewRequestIDMiddleware returns an http.Handler that tags every request with
+// an ID. A well-formed ID sent by the client (or an upstream proxy) is reused,
+// otherwise a new one is generated. The ID is echoed in the response headers
+// and available to handlers through RequestIDFromContext.
+func NewRequestIDMiddleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(RequestIDHeader)
+			if !isValidRequestID(requestID) {
+				requestID = newRequestID()
+			}
+
+			w.Header().Set(RequestIDHeader, requestID)
+
+			ctx := context.WithValue(r.Context(), requestIDContextKey{}, requestID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestIDFromContext returns the request ID assigned by the request ID
+// middleware, or an empty string if there isn't one
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Only accept IDs that are safe to log and echo back
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range requestID {
+		isAlphaNumeric := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlphaNumeric && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+
+	return true
+}
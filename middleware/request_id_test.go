@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		sent   string
+		reused bool
+	}{
+		{"none sent", "", false},
+		{"valid", "abc-123_x.y", true},
+		{"invalid characters", "abc 123", false},
+		{"header injection", "abc\r\nX-Other: 1", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"longest allowed", strings.Repeat("a", maxRequestIDLength), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.sent != "" {
+				request.Header.Set(RequestIDHeader, test.sent)
+			}
+
+			var fromContext string
+			recorder := httptest.NewRecorder()
+			NewRequestIDMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = RequestIDFromContext(r.Context())
+			})).ServeHTTP(recorder, request)
+
+			echoed := recorder.Header().Get(RequestIDHeader)
+			if echoed == "" || echoed != fromContext {
+				t.Fatalf("echoed %q, handler saw %q", echoed, fromContext)
+			}
+			if reused := echoed == test.sent; reused != test.reused {
+				t.Errorf("got %q for %q, want reused %v", echoed, test.sent, test.reused)
+			}
+			if !isValidRequestID(echoed) {
+				t.Errorf("echoed invalid ID %q", echoed)
+			}
+		})
+	}
+}
+
+func TestRequestIDFromContextWithoutMiddleware(t *testing.T) {
+	if id := RequestIDFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); id != "" {
+		t.Errorf("got %q, want no ID", id)
+	}
+}
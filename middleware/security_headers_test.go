@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		tls     bool
+		want    map[string]string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"X-Frame-Options":           "DENY",
+				"Strict-Transport-Security": "",
+			},
+		},
+		{
+			name: "defaults over TLS",
+			tls:  true,
+			want: map[string]string{
+				"X-Content-Type-Options":    "nosniff",
+				"Strict-Transport-Security": strictTransportSecurity,
+			},
+		},
+		{
+			name:    "custom",
+			headers: map[string]string{"X-Frame-Options": "SAMEORIGIN"},
+			want: map[string]string{
+				"X-Frame-Options":        "SAMEORIGIN",
+				"X-Content-Type-Options": "",
+			},
+		},
+		{
+			name:    "custom HSTS over TLS",
+			headers: map[string]string{"Strict-Transport-Security": "max-age=60"},
+			tls:     true,
+			want: map[string]string{
+				"Strict-Transport-Security": "max-age=60",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.tls {
+				request.TLS = &tls.ConnectionState{}
+			}
+
+			recorder := httptest.NewRecorder()
+			NewSecurityHeadersMiddleware(test.headers)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(recorder, request)
+
+			for name, want := range test.want {
+				if got := recorder.Header().Get(name); got != want {
+					t.Errorf("%s: got %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
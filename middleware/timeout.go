@@ -0,0 +1,15 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// NewTimeoutMiddleware returns an http.Handler that cancels each request's
+// context after timeout and responds with a 503 if the handler hasn't
+// finished by then
+func NewTimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, timeout, http.StatusText(http.StatusServiceUnavailable))
+	}
+}
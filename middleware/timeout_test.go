@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		wantStatus int
+		wantBody   string
+	}{
+		{"finishes in time", 0, http.StatusOK, "ok"},
+		{"times out", time.Second, http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := NewTimeoutMiddleware(50 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(test.delay):
+					w.Write([]byte("ok"))
+				case <-r.Context().Done():
+				}
+			}))
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if body := recorder.Body.String(); body != test.wantBody {
+				t.Errorf("got body %q, want %q", body, test.wantBody)
+			}
+		})
+	}
+}
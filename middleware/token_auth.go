@@ -0,0 +1,36 @@
+package server
+
+import (
+	"crypto/sha256"
+	"net/http"
+)
+
+// NewTokenAuthMiddleware returns an http.Handler that authenticates requests
+// against a set of access tokens, each belonging to its own Principal. Tokens
+// are read the same way as NewAuthMiddleware.
+func NewTokenAuthMiddleware(principals map[string]*Principal) func(http.Handler) http.Handler {
+	// Only keep digests of the tokens around
+	hashedTokens := make(map[[sha256.Size]byte]*Principal, len(principals))
+	for token, principal := range principals {
+		hashedTokens[sha256.Sum256([]byte(token))] = principal
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accessToken := r.Header.Get("Access-Token")
+			if accessToken == "" {
+				accessToken = r.URL.Query().Get("Access-Token")
+			}
+
+			principal, ok := hashedTokens[sha256.Sum256([]byte(accessToken))]
+			if accessToken == "" || !ok {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("no"))
+
+				return
+			}
+
+			next.ServeHTTP(w, withRequestPrincipal(r, principal))
+		})
+	}
+}
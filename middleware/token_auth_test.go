@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenAuthMiddleware(t *testing.T) {
+	alice := &Principal{Name: "alice"}
+	bob := &Principal{Name: "bob"}
+	middleware := NewTokenAuthMiddleware(map[string]*Principal{
+		"alice-token": alice,
+		"bob-token":   bob,
+	})
+
+	tests := []struct {
+		name          string
+		header        string
+		query         string
+		wantStatus    int
+		wantPrincipal *Principal
+	}{
+		{"header", "alice-token", "", http.StatusOK, alice},
+		{"query", "", "bob-token", http.StatusOK, bob},
+		{"header wins over query", "alice-token", "bob-token", http.StatusOK, alice},
+		{"unknown token", "carol-token", "", http.StatusForbidden, nil},
+		{"no token", "", "", http.StatusForbidden, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := "/"
+			if test.query != "" {
+				target += "?Access-Token=" + test.query
+			}
+			request := httptest.NewRequest(http.MethodGet, target, nil)
+			if test.header != "" {
+				request.Header.Set("Access-Token", test.header)
+			}
+
+			var principal *Principal
+			recorder := httptest.NewRecorder()
+			middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				principal = PrincipalFromContext(r.Context())
+			})).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.wantStatus {
+				t.Errorf("got status %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if principal != test.wantPrincipal {
+				t.Errorf("got principal %+v, want %+v", principal, test.wantPrincipal)
+			}
+		})
+	}
+}